@@ -0,0 +1,61 @@
+package iprs_cert_test
+
+import (
+	"testing"
+
+	c "github.com/dirkmc/go-iprs/certificate"
+	tu "github.com/dirkmc/go-iprs/test"
+)
+
+func TestIdConstraints(t *testing.T) {
+	caCert, caPk, err := tu.GenerateCACertificate("ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := tu.GenerateIdConstrainedCertificate("bob cert", []string{"bobs-repo", "shared_*"}, caCert, caPk, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The extension is critical
+	critical := false
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(c.IdConstraintsOID) {
+			critical = ext.Critical
+		}
+	}
+	if !critical {
+		t.Fatal("Expected ID constraints extension to be critical")
+	}
+
+	ids, ok, err := c.GetIdConstraints(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(ids) != 2 || ids[0] != "bobs-repo" || ids[1] != "shared_*" {
+		t.Fatalf("Unexpected ID constraints %v", ids)
+	}
+
+	for _, id := range []string{"bobs-repo", "shared_", "shared_photos"} {
+		if err = c.CheckIdConstraints(cert, id); err != nil {
+			t.Fatalf("%s: %s", id, err)
+		}
+	}
+	for _, id := range []string{"photos", "bobs-repo2", "bobs", "shared"} {
+		if err = c.CheckIdConstraints(cert, id); err != c.IdNotPermittedError {
+			t.Fatalf("%s: Expected IdNotPermittedError, got %v", id, err)
+		}
+	}
+
+	// Certificates without the extension may sign for any ID
+	_, ok, err = c.GetIdConstraints(caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("Expected no ID constraints")
+	}
+	if err = c.CheckIdConstraints(caCert, "photos"); err != nil {
+		t.Fatal(err)
+	}
+}
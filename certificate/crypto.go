@@ -3,6 +3,8 @@ package iprs_cert
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -14,6 +16,7 @@ import (
 )
 
 var CertificateIssuerError = errors.New("Signing certificate was not issued by specified issuing certificate")
+var UnsupportedKeyTypeError = errors.New("Unsupported key type. Expected RSA, ECDSA or Ed25519")
 
 func CheckSignatureFrom(cert, issuer *x509.Certificate) error {
 	if err := cert.CheckSignatureFrom(issuer); err != nil {
@@ -22,13 +25,41 @@ func CheckSignatureFrom(cert, issuer *x509.Certificate) error {
 	return nil
 }
 
-func Sign(pk *rsa.PrivateKey, data []byte) ([]byte, error) {
-	hashed := sha256.Sum256(data)
-	return rsa.SignPKCS1v15(rand.Reader, pk, crypto.SHA256, hashed[:])
+// Sign signs the data with the private key. RSA keys sign a SHA256 hash
+// of the data with PKCS1v15, ECDSA keys sign a SHA256 hash of the data
+// and Ed25519 keys sign the data itself.
+func Sign(pk crypto.Signer, data []byte) ([]byte, error) {
+	switch pk.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		hashed := sha256.Sum256(data)
+		return pk.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	case ed25519.PublicKey:
+		return pk.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	return nil, UnsupportedKeyTypeError
 }
 
+// CheckSignature checks that the data was signed by the private key
+// corresponding to the certificate's public key, using the signature
+// algorithm that Sign uses for that type of key
 func CheckSignature(cert *x509.Certificate, data, signedData []byte) error {
-	return cert.CheckSignature(x509.SHA256WithRSA, data, signedData)
+	algo, err := signatureAlgorithm(cert)
+	if err != nil {
+		return err
+	}
+	return cert.CheckSignature(algo, data, signedData)
+}
+
+func signatureAlgorithm(cert *x509.Certificate) (x509.SignatureAlgorithm, error) {
+	switch cert.PublicKeyAlgorithm {
+	case x509.RSA:
+		return x509.SHA256WithRSA, nil
+	case x509.ECDSA:
+		return x509.ECDSAWithSHA256, nil
+	case x509.Ed25519:
+		return x509.PureEd25519, nil
+	}
+	return x509.UnknownSignatureAlgorithm, UnsupportedKeyTypeError
 }
 
 func GetCertificateHash(cert *x509.Certificate) (string, error) {
@@ -39,6 +70,12 @@ func GetCertificateHash(cert *x509.Certificate) (string, error) {
 	return getCertificateHashFromBytes(b), nil
 }
 
+// GetPublicKeyHash gets the hash of the certificate's public key. Unlike
+// the certificate hash it doesn't depend on how the certificate is encoded.
+func GetPublicKeyHash(cert *x509.Certificate) string {
+	return u.Hash(cert.RawSubjectPublicKeyInfo).B58String()
+}
+
 func getCertificateHashFromBytes(bytes []byte) string {
 	return u.Hash(bytes).B58String()
 }
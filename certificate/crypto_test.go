@@ -0,0 +1,48 @@
+package iprs_cert_test
+
+import (
+	"testing"
+
+	c "github.com/dirkmc/go-iprs/certificate"
+	tu "github.com/dirkmc/go-iprs/test"
+)
+
+func TestSignAndCheckSignature(t *testing.T) {
+	data := []byte("some data")
+	keyTypes := map[string]tu.KeyType{
+		"RSA":     tu.RSA,
+		"ECDSA":   tu.ECDSA,
+		"Ed25519": tu.Ed25519,
+	}
+
+	for name, kt := range keyTypes {
+		cert, pk, err := tu.GenerateCACertificateWithKeyType("cert", kt)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		otherCert, _, err := tu.GenerateCACertificateWithKeyType("other cert", kt)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		sig, err := c.Sign(pk, data)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		err = c.CheckSignature(cert, data, sig)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		err = c.CheckSignature(cert, []byte("other data"), sig)
+		if err == nil {
+			t.Fatalf("%s: Failed to return error for signature over different data", name)
+		}
+
+		err = c.CheckSignature(otherCert, data, sig)
+		if err == nil {
+			t.Fatalf("%s: Failed to return error for signature with different cert", name)
+		}
+	}
+}
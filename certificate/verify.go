@@ -0,0 +1,59 @@
+package iprs_cert
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+var CertificateExpiredError = errors.New("Certificate has expired")
+var CertificateNotYetValidError = errors.New("Certificate is not yet valid")
+var IssuerNotCAError = errors.New("Issuing certificate is not a CA certificate")
+var CertificateKeyUsageError = errors.New("Certificate key usage does not permit digital signatures")
+var CertificateRevokedError = errors.New("Certificate has been revoked")
+
+// CheckValidityPeriod checks that the certificate is valid at the given time
+func CheckValidityPeriod(cert *x509.Certificate, now time.Time) error {
+	if now.Before(cert.NotBefore) {
+		return CertificateNotYetValidError
+	}
+	if now.After(cert.NotAfter) {
+		return CertificateExpiredError
+	}
+	return nil
+}
+
+// CheckIsCA checks that the certificate may be used to issue other certificates
+func CheckIsCA(cert *x509.Certificate) error {
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return IssuerNotCAError
+	}
+	return nil
+}
+
+// CheckDigitalSignatureUsage checks that the certificate may be used to
+// sign data (ie records)
+func CheckDigitalSignatureUsage(cert *x509.Certificate) error {
+	if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return CertificateKeyUsageError
+	}
+	return nil
+}
+
+var CertificatePathLenError = errors.New("Certificate chain exceeds issuing certificate's maximum path length")
+
+// CheckMaxPathLen checks that the issuer's basic constraints allow it to
+// have the given number of intermediate certificates below it in a chain
+func CheckMaxPathLen(issuer *x509.Certificate, intermediates int) error {
+	if !issuer.BasicConstraintsValid {
+		return nil
+	}
+	// A MaxPathLen of -1, or 0 without MaxPathLenZero, means unset
+	if issuer.MaxPathLen < 0 || (issuer.MaxPathLen == 0 && !issuer.MaxPathLenZero) {
+		return nil
+	}
+	if intermediates > issuer.MaxPathLen {
+		return CertificatePathLenError
+	}
+	return nil
+}
@@ -32,7 +32,9 @@ package iprs
 import (
 	context "context"
 	rsp "github.com/dirkmc/go-iprs/path"
+	psh "github.com/dirkmc/go-iprs/publisher"
 	r "github.com/dirkmc/go-iprs/record"
+	rsv "github.com/dirkmc/go-iprs/resolver"
 	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
 )
 
@@ -46,6 +48,7 @@ import (
 type RecordSystem interface {
 	Resolver
 	Publisher
+	Renewer
 }
 
 // Resolver is an object capable of resolving records.
@@ -75,10 +78,38 @@ type Resolver interface {
 	// Most users should use Resolve, since the default limit works well
 	// in most real-world situations.
 	ResolveN(ctx context.Context, name string, depth int) (*node.Link, []string, error)
+
+	// ResolveTrace performs a recursive lookup in the same way as
+	// ResolveN, and also returns each step of the lookup: the path,
+	// which resolver accepted it, the value it resolved to, whether the
+	// value was cached and, for IPRS paths, details of the record.
+	// Useful for debugging why a name resolves to a particular path.
+	ResolveTrace(ctx context.Context, name string, depth int) (*node.Link, []string, []*rsv.ResolveHop, error)
+
+	// ResolveTraceAsync is like ResolveTrace, but streams each step on
+	// the returned channel as it is resolved.
+	ResolveTraceAsync(ctx context.Context, name string, depth int) <-chan *rsv.ResolveHop
+
+	// Invalidate removes any cached value for the name, so that it is
+	// looked up again the next time it is resolved.
+	Invalidate(name string) error
 }
 
 // Publisher is an object capable of publishing a Record
 type Publisher interface {
 	// Publish establishes a name-value mapping.
 	Publish(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) error
+
+	// PublishWithOpts establishes a name-value mapping with the given
+	// options, eg to force an older record to replace a newer one.
+	PublishWithOpts(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, opts *psh.PublishOpts) error
+}
+
+// Renewer is an object capable of renewing a Record
+type Renewer interface {
+	// Renew publishes a new record with the same value as the current
+	// record at the IPRS path, with a higher sequence number and the new
+	// validation. The signer must be the same key or certificate that
+	// signed the current record.
+	Renew(ctx context.Context, iprsKey rsp.IprsPath, signer r.RecordSigner, vl r.RecordValidation) (*r.Record, error)
 }
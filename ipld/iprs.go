@@ -2,6 +2,7 @@ package iprs_ipld
 
 import (
 	"errors"
+	"fmt"
 	"math"
 
 	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
@@ -10,7 +11,14 @@ import (
 	cborld "gx/ipfs/QmeZv9VXw2SfVbX55LV6kGTWASKBc9ZxAVqGBeJcDGdoXy/go-ipld-cbor"
 )
 
-const Version = 1
+// Version is the version of newly created IPRS records. Records with a
+// version below SequenceVersion were created before records had sequence
+// numbers.
+const Version = 2
+
+// SequenceVersion is the first version of IPRS records that has a
+// sequence number
+const SequenceVersion = 2
 
 // TODO: Add to https://github.com/ipfs/go-cid/blob/master/cid.go
 const CodecIprsCbor = 0xd0
@@ -54,6 +62,7 @@ type Node struct {
 
 	Version   uint64
 	Value     []byte
+	Sequence  uint64
 	Validity  *Validity
 	Signature []byte
 }
@@ -67,11 +76,12 @@ func (n *Node) Loggable() map[string]interface{} {
 
 var _ node.Node = (*Node)(nil)
 
-func NewIprsNode(value []byte, validity *Validity, signature []byte) (*Node, error) {
+func NewIprsNode(value []byte, sequence uint64, validity *Validity, signature []byte) (*Node, error) {
 	// Store the fields as a CBOR map
 	obj := map[string]interface{}{
 		"version":   Version,
 		"value":     value,
+		"sequence":  sequence,
 		"validity":  validity.Map(),
 		"signature": signature,
 	}
@@ -85,6 +95,7 @@ func NewIprsNode(value []byte, validity *Validity, signature []byte) (*Node, err
 		Version:   Version,
 		Node:      *n,
 		Value:     value,
+		Sequence:  sequence,
 		Validity:  validity,
 		Signature: signature,
 	}, nil
@@ -103,6 +114,9 @@ func DecodeIprsBlock(block blocks.Block) (*Node, error) {
 	if err != nil || !ok {
 		return nil, errors.New("incorrectly formatted version")
 	}
+	if version > Version {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
 
 	vali, _, err := n.Resolve([]string{"value"})
 	val, ok := vali.([]byte)
@@ -110,6 +124,19 @@ func DecodeIprsBlock(block blocks.Block) (*Node, error) {
 		return nil, errors.New("incorrectly formatted value")
 	}
 
+	// Records from before sequence numbers were added have a sequence
+	// number of zero
+	var seq uint64
+	seqi, _, err := n.Resolve([]string{"sequence"})
+	if version >= SequenceVersion {
+		seq, ok = seqi.(uint64)
+		if err != nil || !ok {
+			return nil, errors.New("incorrectly formatted sequence")
+		}
+	} else if err == nil {
+		return nil, errors.New("unexpected sequence in record without sequence numbers")
+	}
+
 	_, _, err = n.Resolve([]string{"validity"})
 	if err != nil {
 		return nil, errors.New("incorrectly formatted validity")
@@ -144,9 +171,10 @@ func DecodeIprsBlock(block blocks.Block) (*Node, error) {
 	}
 
 	return &Node{
-		Node:    *n,
-		Version: version,
-		Value:   val,
+		Node:     *n,
+		Version:  version,
+		Value:    val,
+		Sequence: seq,
 		Validity: &Validity{
 			VerificationType: IprsVerificationType(vft),
 			Verification:     verificationi,
@@ -24,9 +24,10 @@ func TestMarshalIprsNodeRoundtrip(t *testing.T) {
 		Validation:       validation,
 	}
 	signature := []byte("sig")
+	sequence := uint64(3)
 
 	// 1. Newly constructed Node
-	o, err := NewIprsNode(valueCid.Bytes(), validity, signature)
+	o, err := NewIprsNode(valueCid.Bytes(), sequence, validity, signature)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -66,13 +67,22 @@ func TestMarshalIprsNodeRoundtrip(t *testing.T) {
 			t.Fatalf("value is %s, expected %s", val, valueCid)
 		}
 
+		seqi, _, err := n.Resolve([]string{"sequence"})
+		seq, ok := seqi.(uint64)
+		if err != nil || !ok {
+			t.Fatal("incorrectly formatted sequence")
+		}
+		if seq != sequence {
+			t.Fatalf("sequence is %d, expected %d", seq, sequence)
+		}
+
 		versioni, _, err := n.Resolve([]string{"version"})
 		versionr, ok := versioni.(uint64)
 		if err != nil || !ok {
 			t.Fatal("incorrectly formatted version")
 		}
-		if IprsVerificationType(versionr) != 1 {
-			t.Fatalf("version is %d, expected %d", versionr, 1)
+		if versionr != Version {
+			t.Fatalf("version is %d, expected %d", versionr, Version)
 		}
 
 		vfti, _, err := n.Resolve([]string{"validity", "verificationType"})
@@ -123,6 +133,7 @@ func TestMarshalIprsNodeRoundtrip(t *testing.T) {
 		full := []string{
 			"version",
 			"value",
+			"sequence",
 			"validity",
 			"validity/verificationType",
 			"validity/verification",
@@ -135,6 +146,7 @@ func TestMarshalIprsNodeRoundtrip(t *testing.T) {
 		top := []string{
 			"version",
 			"value",
+			"sequence",
 			"validity",
 			"signature",
 		}
@@ -162,11 +174,76 @@ func TestMarshalIprsNodeRoundtrip(t *testing.T) {
 	}
 
 	tests(o)
+	if nd.Sequence != sequence || nb.Sequence != sequence {
+		t.Fatalf("decoded sequence is %d, expected %d", nb.Sequence, sequence)
+	}
 	tests(nb)
 	tests(nd)
 	tests(nd.Copy())
 }
 
+func TestDecodeIprsBlockWithoutSequence(t *testing.T) {
+	validity := &Validity{
+		VerificationType: VerificationType_Key,
+		Verification:     map[string]*cid.Cid{"mycid": cid.NewCidV0(u.Hash([]byte("value")))},
+		ValidationType:   ValidationType_EOL,
+		Validation:       []byte("validation"),
+	}
+	var encode = func(version uint64, seq interface{}) *Node {
+		obj := map[string]interface{}{
+			"version":   version,
+			"value":     []byte("value"),
+			"validity":  validity.Map(),
+			"signature": []byte("sig"),
+		}
+		if seq != nil {
+			obj["sequence"] = seq
+		}
+		n, err := ipldCborNodeWithCodec(CodecIprsCbor, obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := blocks.NewBlockWithCid(n.RawData(), n.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		nd, _ := DecodeIprsBlock(b)
+		return nd
+	}
+
+	// Records from before sequence numbers were added have a sequence
+	// number of zero
+	nd := encode(1, nil)
+	if nd == nil {
+		t.Fatal("Failed to decode record without sequence number")
+	}
+	if nd.Version != 1 || nd.Sequence != 0 {
+		t.Fatalf("Decoded version %d and sequence %d, expected 1 and 0", nd.Version, nd.Sequence)
+	}
+
+	// Older records can't have a sequence number, as it isn't signed
+	if encode(1, uint64(5)) != nil {
+		t.Fatal("Expected error decoding old record with sequence number")
+	}
+
+	// Newer records must have a sequence number
+	if encode(SequenceVersion, nil) != nil {
+		t.Fatal("Expected error decoding record without sequence number")
+	}
+	if encode(SequenceVersion, "5") != nil {
+		t.Fatal("Expected error decoding record with invalid sequence number")
+	}
+	nd = encode(SequenceVersion, uint64(5))
+	if nd == nil || nd.Sequence != 5 {
+		t.Fatal("Failed to decode record with sequence number")
+	}
+
+	// Records with a version from the future are rejected
+	if encode(Version+1, uint64(5)) != nil {
+		t.Fatal("Expected error decoding record with unsupported version")
+	}
+}
+
 func assertStringsEqual(t *testing.T, a, b []string) {
 	sort.Strings(a)
 	sort.Strings(b)
@@ -0,0 +1,96 @@
+package iprs_ipld
+
+import (
+	"errors"
+
+	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
+	blocks "gx/ipfs/QmYsEQydGrsxNZfAiskvQ76N2xE9hDQtSAkRSynwMiUK3c/go-block-format"
+	cborld "gx/ipfs/QmeZv9VXw2SfVbX55LV6kGTWASKBc9ZxAVqGBeJcDGdoXy/go-ipld-cbor"
+)
+
+// TODO: Add to https://github.com/ipfs/go-cid/blob/master/cid.go
+const CodecRevocationListCbor = 0xd1
+
+// A list of revoked certificates
+type RevocationList struct {
+	cborld.Node
+
+	Version uint64
+	// Revoked certificates, each identified by the hash of its issuer's
+	// public key and its serial number in base 10, ie
+	// <issuer key hash>/<serial number>
+	Certs []string
+}
+
+func (n *RevocationList) Loggable() map[string]interface{} {
+	return map[string]interface{}{
+		"node_type": "revocation_list",
+		"cid":       n.Cid(),
+	}
+}
+
+var _ node.Node = (*RevocationList)(nil)
+
+func NewRevocationListNode(certs []string) (*RevocationList, error) {
+	obj := map[string]interface{}{
+		"version": Version,
+		"certs":   certs,
+	}
+
+	n, err := ipldCborNodeWithCodec(CodecRevocationListCbor, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevocationList{
+		Node:    *n,
+		Version: Version,
+		Certs:   certs,
+	}, nil
+}
+
+func DecodeRevocationListBlock(block blocks.Block) (*RevocationList, error) {
+	n, err := ipldCborNodeFromBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	versioni, _, err := n.Resolve([]string{"version"})
+	version, ok := versioni.(uint64)
+	if err != nil || !ok {
+		return nil, errors.New("incorrectly formatted version")
+	}
+
+	certsi, _, err := n.Resolve([]string{"certs"})
+	if err != nil {
+		return nil, errors.New("incorrectly formatted certs")
+	}
+	ca, ok := certsi.([]interface{})
+	if !ok {
+		return nil, errors.New("incorrectly formatted certs")
+	}
+	certs := make([]string, len(ca))
+	for i := range ca {
+		certs[i], ok = ca[i].(string)
+		if !ok {
+			return nil, errors.New("incorrectly formatted cert")
+		}
+	}
+
+	return &RevocationList{
+		Node:    *n,
+		Version: version,
+		Certs:   certs,
+	}, nil
+}
+
+// Used by IPLD's block decoder to decode blocks into generic IPLD nodes
+func DecodeRevocationListBlockGenericNode(block blocks.Block) (node.Node, error) {
+	return DecodeRevocationListBlock(block)
+}
+
+var _ node.DecodeBlockFunc = DecodeRevocationListBlockGenericNode
+
+func init() {
+	node.Register(CodecRevocationListCbor, DecodeRevocationListBlockGenericNode)
+}
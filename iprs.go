@@ -40,9 +40,19 @@ func NewRecordSystem(vstore routing.ValueStore, dag mdag.DAGService, opts *rsv.R
 	return &mprs{resolver, publisher}
 }
 
+// NewRepublisher creates a service that periodically republishes records
+// published through it. EOL records tracked with a signer are re-signed to
+// be valid for DefaultRecordTTL. Records are published through the record
+// system, so that its resolver doesn't return stale cached values.
+func NewRepublisher(rs RecordSystem, vstore routing.ValueStore, dag mdag.DAGService) *psh.Republisher {
+	rp := psh.NewRepublisherWithPublisher(vstore, dag, rs)
+	rp.RecordLifetime = DefaultRecordTTL
+	return rp
+}
+
 // Resolve implements Resolver.
 func (rs *mprs) Resolve(ctx context.Context, name string) (*node.Link, []string, error) {
-	return rs.ResolveN(ctx, name, rsv.DefaultDepthLimit)
+	return rs.ResolveN(ctx, name, rs.resolver.DepthLimit())
 }
 
 // ResolveN implements Resolver.
@@ -50,7 +60,65 @@ func (rs *mprs) ResolveN(ctx context.Context, name string, depth int) (*node.Lin
 	return rs.resolver.Resolve(ctx, name, depth)
 }
 
+// ResolveTrace implements Resolver.
+func (rs *mprs) ResolveTrace(ctx context.Context, name string, depth int) (*node.Link, []string, []*rsv.ResolveHop, error) {
+	return rs.resolver.ResolveTrace(ctx, name, depth)
+}
+
+// ResolveTraceAsync implements Resolver.
+func (rs *mprs) ResolveTraceAsync(ctx context.Context, name string, depth int) <-chan *rsv.ResolveHop {
+	return rs.resolver.ResolveTraceAsync(ctx, name, depth)
+}
+
+// Invalidate implements Resolver.
+func (rs *mprs) Invalidate(name string) error {
+	return rs.resolver.Invalidate(name)
+}
+
 // Publish implements Publisher
 func (rs *mprs) Publish(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) error {
-	return rs.publisher.Publish(ctx, iprsKey, record)
+	return rs.PublishWithOpts(ctx, iprsKey, record, nil)
+}
+
+// PublishWithOpts implements Publisher
+func (rs *mprs) PublishWithOpts(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, opts *psh.PublishOpts) error {
+	err := rs.publisher.PublishWithOpts(ctx, iprsKey, record, opts)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the resolver doesn't return the previously published
+	// value from its cache
+	if err = rs.resolver.Invalidate(iprsKey.BasePath()); err != nil {
+		log.Warningf("Failed to invalidate cached value for %s: %s", iprsKey.BasePath(), err)
+	}
+	return nil
+}
+
+// Renew implements Renewer
+func (rs *mprs) Renew(ctx context.Context, iprsKey rsp.IprsPath, signer r.RecordSigner, vl r.RecordValidation) (*r.Record, error) {
+	cur, err := rs.resolver.GetRecord(ctx, iprsKey)
+	if err != nil {
+		log.Warningf("Failed to get current record at %s for renewal: %s", iprsKey, err)
+		return nil, err
+	}
+
+	if err = r.CheckSigner(iprsKey, cur, signer); err != nil {
+		log.Warningf("Cannot renew record at %s: %s", iprsKey, err)
+		return nil, err
+	}
+
+	record, err := r.NewRecord(vl, signer, cur.Value, cur.Sequence+1)
+	if err != nil {
+		return nil, err
+	}
+
+	bp, err := rsp.FromString(iprsKey.BasePath())
+	if err != nil {
+		return nil, err
+	}
+	if err = rs.Publish(ctx, bp, record); err != nil {
+		return nil, err
+	}
+	return record, nil
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	psh "github.com/dirkmc/go-iprs/publisher"
 	rec "github.com/dirkmc/go-iprs/record"
 	rsv "github.com/dirkmc/go-iprs/resolver"
 	tu "github.com/dirkmc/go-iprs/test"
@@ -47,7 +48,7 @@ func TestPublishAndResolve(t *testing.T) {
 	eol := time.Now().Add(time.Hour)
 	validation := rec.NewEolRecordValidation(eol)
 	signer := rec.NewKeyRecordSigner(pk)
-	record, err := rec.NewRecord(validation, signer, p1.Bytes())
+	record, err := rec.NewRecord(validation, signer, p1.Bytes(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -80,7 +81,7 @@ func TestPublishAndResolve(t *testing.T) {
 	}
 	eol = time.Now().Add(time.Minute * 10)
 	validation = rec.NewEolRecordValidation(eol)
-	record, err = rec.NewRecord(validation, signer, p2.Bytes())
+	record, err = rec.NewRecord(validation, signer, p2.Bytes(), 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -132,7 +133,7 @@ func TestPublishAndResolveSharedKey(t *testing.T) {
 	eol := time.Now().Add(time.Hour)
 	validation := rec.NewEolRecordValidation(eol)
 	signer := rec.NewCertRecordSigner(caCert, caPk)
-	record, err := rec.NewRecord(validation, signer, p1.Bytes())
+	record, err := rec.NewRecord(validation, signer, p1.Bytes(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -171,7 +172,7 @@ func TestPublishAndResolveSharedKey(t *testing.T) {
 	eol = time.Now().Add(time.Minute * 10)
 	validation = rec.NewEolRecordValidation(eol)
 	signer = rec.NewCertRecordSigner(childCert, childPk)
-	record, err = rec.NewRecord(validation, signer, p2.Bytes())
+	record, err = rec.NewRecord(validation, signer, p2.Bytes(), 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -235,7 +236,7 @@ func TestPublishAndResolveGitNode(t *testing.T) {
 	eol := time.Now().Add(time.Hour)
 	validation := rec.NewEolRecordValidation(eol)
 	signer := rec.NewKeyRecordSigner(pk)
-	record, err := rec.NewRecord(validation, signer, p1.Bytes())
+	record, err := rec.NewRecord(validation, signer, p1.Bytes(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -261,3 +262,279 @@ func TestPublishAndResolveGitNode(t *testing.T) {
 		t.Fatal("Got back incorrect value")
 	}
 }
+
+func TestPublishAndResolveKeyTypes(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	rs := NewRecordSystem(r, dag, rsv.NoCacheOpts)
+
+	p1, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tk := range tu.TestKeys {
+		name := tk.Name
+		pk, err := tk.PrivKey()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		// Create and publish an EOL record signed with the key
+		validation := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+		signer := rec.NewKeyRecordSigner(pk)
+		record, err := rec.NewRecord(validation, signer, p1.Bytes(), 0)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		iprsKey, err := signer.BasePath("myrec")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		err = rs.Publish(ctx, iprsKey, record)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		// Retrieve the record value
+		res, _, err := rs.Resolve(ctx, iprsKey.String())
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !res.Cid.Equals(p1) {
+			t.Fatalf("%s: Got back incorrect value", name)
+		}
+	}
+}
+
+func TestRenew(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	rs := NewRecordSystem(r, dag, rsv.NoCacheOpts)
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Publish a record that expires soon
+	signer := rec.NewKeyRecordSigner(pk)
+	record, err := rec.NewRecord(rec.NewEolRecordValidation(time.Now().Add(time.Minute)), signer, p1.Bytes(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := signer.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Renew the record with a later EOL
+	eol := time.Now().Add(time.Hour * 2)
+	renewed, err := rs.Renew(ctx, iprsKey, signer, rec.NewEolRecordValidation(eol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Sequence != 4 {
+		t.Fatalf("Expected sequence number 4, got %d", renewed.Sequence)
+	}
+	if !bytes.Equal(renewed.Value, p1.Bytes()) {
+		t.Fatal("Expected renewed record to have the same value")
+	}
+	renewedEol, err := rec.EolParseValidation(renewed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewedEol.Unix() != eol.Unix() {
+		t.Fatalf("Expected EOL %s, got %s", eol, renewedEol)
+	}
+
+	// The renewed record should be resolved
+	res, _, err := rs.Resolve(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Cid.Equals(p1) {
+		t.Fatal("Got back incorrect value")
+	}
+
+	// Renewing with a different key should fail
+	_, err = rs.Renew(ctx, iprsKey, rec.NewKeyRecordSigner(otherPk), rec.NewEolRecordValidation(eol))
+	if err != rec.ErrSignerMismatch {
+		t.Fatalf("Expected ErrSignerMismatch, got %v", err)
+	}
+
+	// Renewing a record signed with a certificate
+	caCert, caPk, err := tu.GenerateCACertificate("ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	childCert, childPk, err := tu.GenerateChildCertificate("child cert", caCert, caPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner := rec.NewCertRecordSigner(caCert, caPk)
+	childSigner := rec.NewCertRecordSigner(childCert, childPk)
+	certKey, err := caSigner.BasePath("myrepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = rec.NewRecord(rec.NewEolRecordValidation(time.Now().Add(time.Minute)), childSigner, p1.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.Publish(ctx, certKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The record was signed by the child certificate so the CA
+	// certificate can't renew it
+	_, err = rs.Renew(ctx, certKey, caSigner, rec.NewEolRecordValidation(eol))
+	if err != rec.ErrSignerMismatch {
+		t.Fatalf("Expected ErrSignerMismatch, got %v", err)
+	}
+	renewed, err = rs.Renew(ctx, certKey, childSigner, rec.NewEolRecordValidation(eol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Sequence != 1 {
+		t.Fatalf("Expected sequence number 1, got %d", renewed.Sequence)
+	}
+
+	// Renewing a path with no record should fail
+	missingKey, err := signer.BasePath("missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rs.Renew(ctx, missingKey, signer, rec.NewEolRecordValidation(eol))
+	if err == nil {
+		t.Fatal("Expected error renewing path with no record")
+	}
+}
+
+func TestPublishInvalidatesCache(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	// Use the default cache options, so that resolved values are cached
+	rs := NewRecordSystem(r, dag, nil)
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := rec.NewKeyRecordSigner(pk)
+	iprsKey, err := signer.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newRecord = func(p string, seq uint64) (*cid.Cid, *rec.Record) {
+		c, err := cid.Parse(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		validation := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+		record, err := rec.NewRecord(validation, signer, c.Bytes(), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, record
+	}
+
+	var checkResolves = func(expected *cid.Cid) {
+		res, _, err := rs.Resolve(ctx, iprsKey.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Cid.Equals(expected) {
+			t.Fatal("Got back incorrect value")
+		}
+	}
+
+	p1, record := newRecord("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN", 0)
+	err = rs.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResolves(p1)
+
+	// Publishing through the record system should invalidate the cache
+	p2, record := newRecord("/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy", 1)
+	err = rs.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResolves(p2)
+
+	// Publishing from elsewhere doesn't invalidate the cache
+	p3, record := newRecord("/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", 2)
+	err = psh.NewDHTPublisher(r, dag).Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResolves(p2)
+
+	// Until the path is explicitly invalidated
+	err = rs.Invalidate(iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResolves(p3)
+
+	// Publishing through the republisher should invalidate the cache
+	rp := NewRepublisher(rs, r, dag)
+	p4, record := newRecord("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN", 3)
+	err = rp.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResolves(p4)
+
+	// And so should re-signing a record that is about to expire
+	validation := rec.NewEolRecordValidation(time.Now().Add(time.Minute))
+	record, err = rec.NewRecord(validation, signer, p4.Bytes(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rp.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.Track(iprsKey, record, signer)
+	checkResolves(p4)
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, hops, err := rs.ResolveTrace(ctx, iprsKey.String(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hops[0].CacheHit {
+		t.Fatal("Expected renewed record not to be served from the cache")
+	}
+}
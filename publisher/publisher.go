@@ -2,39 +2,109 @@ package iprs_publisher
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	ld "github.com/dirkmc/go-iprs/ipld"
 	rsp "github.com/dirkmc/go-iprs/path"
 	rec "github.com/dirkmc/go-iprs/record"
 	mdag "github.com/ipfs/go-ipfs/merkledag"
+	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
 	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
 	logging "gx/ipfs/QmSpJByNKFX1sCsHBEp3R73FL4NF6FnQTEGyNAXHm2GS52/go-log"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
 )
 
 var log = logging.Logger("iprs_publisher")
 
 const PublishTimeout = time.Second * 10
 
+// CurrentRecordTimeout is the maximum time spent retrieving the records
+// currently published at an IPRS path, before publishing a new record
+const CurrentRecordTimeout = time.Second * 5
+
+// The number of current records requested from the value store
+const currentRecordCount = 16
+
+// ErrUnsignedRecord is returned when publishing a record with no signature
+var ErrUnsignedRecord = errors.New("Record is not signed")
+
+// RecordConflictError is returned when publishing a record that is older
+// (eg has a lower sequence number) than the record currently published
+// at the IPRS path
+type RecordConflictError struct {
+	IprsKey rsp.IprsPath
+	// The newer record that is currently published
+	Current *rec.Record
+}
+
+func (e *RecordConflictError) Error() string {
+	return fmt.Sprintf("A newer record [%s] with sequence number %d is already published at %s", e.Current.Cid(), e.Current.Sequence, e.IprsKey)
+}
+
+type PublishOpts struct {
+	// Force the record to be published even if it is older than the
+	// record currently published at the IPRS path
+	Force bool
+}
+
 type iprsPublisher struct {
-	vs  routing.ValueStore
-	dag mdag.DAGService
+	vs       routing.ValueStore
+	dag      mdag.DAGService
+	verifier *rec.MasterRecordVerifier
+	checker  rec.RecordChecker
 }
 
 // NewDHTPublisher constructs a publisher for the IPFS Routing name system.
 func NewDHTPublisher(vs routing.ValueStore, dag mdag.DAGService) *iprsPublisher {
-	return &iprsPublisher{vs, dag}
+	return &iprsPublisher{
+		vs:       vs,
+		dag:      dag,
+		verifier: rec.NewRevocationCheckingMasterRecordVerifier(vs, dag),
+		checker:  rec.MasterRecordChecker,
+	}
 }
 
 // Publish implements Publisher. Accepts an IPRS path and a record,
-// and publishes it out to the routing system
+// and publishes it out to the routing system. The record must be
+// correctly signed for the IPRS path, and must not have expired.
+// If a newer record is already published at the path, returns a
+// *RecordConflictError.
 func (p *iprsPublisher) Publish(ctx context.Context, iprsKey rsp.IprsPath, record *rec.Record) error {
+	return p.PublishWithOpts(ctx, iprsKey, record, nil)
+}
+
+// PublishWithOpts implements Publisher
+func (p *iprsPublisher) PublishWithOpts(ctx context.Context, iprsKey rsp.IprsPath, record *rec.Record, opts *PublishOpts) error {
 	log.Debugf("Publish %s", iprsKey)
 
 	timectx, cancel := context.WithTimeout(ctx, PublishTimeout)
 	defer cancel()
 
-	// Publish the record and associated nodes
-	err := p.publishRecord(timectx, record)
+	// Publish the nodes required to verify the record
+	err := p.publishNodes(record.DependencyNodes())
+	if err != nil {
+		return err
+	}
+
+	// Check the record in the same way the resolver will
+	err = p.checkRecord(timectx, iprsKey, record)
+	if err != nil {
+		return err
+	}
+
+	// Make sure we're not replacing a newer record
+	if opts == nil || !opts.Force {
+		err = p.checkNotOlder(timectx, iprsKey, record)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Publish the record
+	err = p.publishNodes([]node.Node{record})
 	if err != nil {
 		return err
 	}
@@ -43,8 +113,173 @@ func (p *iprsPublisher) Publish(ctx context.Context, iprsKey rsp.IprsPath, recor
 	return p.publishRecordRef(timectx, iprsKey, record)
 }
 
-func (p *iprsPublisher) publishRecord(ctx context.Context, record *rec.Record) error {
-	nodes := append(record.DependencyNodes(), record)
+// Checks that the record is signed by the owner of the IPRS path (ie
+// the key or certificate whose CID is in the path) and is currently valid
+func (p *iprsPublisher) checkRecord(ctx context.Context, iprsKey rsp.IprsPath, record *rec.Record) error {
+	if iprsKey.String() != iprsKey.BasePath() {
+		return fmt.Errorf("Cannot publish to %s: records must be published to a base path, eg %s", iprsKey, iprsKey.BasePath())
+	}
+
+	if len(record.Signature) == 0 {
+		log.Warningf("Refusing to publish unsigned record to %s", iprsKey)
+		return ErrUnsignedRecord
+	}
+
+	err := p.verifier.Verify(ctx, iprsKey, record)
+	if err != nil {
+		log.Warningf("Refusing to publish record to %s that fails verification: %s", iprsKey, err)
+		return err
+	}
+
+	// Records that are not yet valid may be published ahead of time
+	err = p.checker.ValidateRecord(ctx, iprsKey, record)
+	if err != nil && err != rec.ErrPendingRecord {
+		log.Warningf("Refusing to publish invalid record to %s: %s", iprsKey, err)
+		return err
+	}
+
+	return nil
+}
+
+// Checks that the record is not older than the records currently
+// published at the IPRS path
+func (p *iprsPublisher) checkNotOlder(ctx context.Context, iprsKey rsp.IprsPath, record *rec.Record) error {
+	timectx, cancel := context.WithTimeout(ctx, CurrentRecordTimeout)
+	defer cancel()
+
+	current, err := p.getCurrentRecords(timectx, iprsKey)
+	if err != nil {
+		return err
+	}
+	newest, err := newestRecord(current)
+	if err != nil || newest == nil {
+		return err
+	}
+
+	older, err := isNewer(newest, record)
+	if err != nil {
+		return err
+	}
+	if !older {
+		return nil
+	}
+
+	log.Warningf("Refusing to replace newer record [%s] at %s with [%s]", newest.Cid(), iprsKey, record.Cid())
+	return &RecordConflictError{iprsKey, newest}
+}
+
+// Gets the newest of the records, or nil if there are none
+func newestRecord(recs []*rec.Record) (*rec.Record, error) {
+	var newest *rec.Record
+	for _, r := range recs {
+		if newest == nil {
+			newest = r
+			continue
+		}
+		n, err := isNewer(r, newest)
+		if err != nil {
+			return nil, err
+		}
+		if n {
+			newest = r
+		}
+	}
+	return newest, nil
+}
+
+// Checks if record a is newer than record b. A record with a higher
+// sequence number is newer. If the sequence numbers are the same, the
+// record that is valid until later is newer, regardless of its
+// validation type. If neither is newer, returns false.
+func isNewer(a *rec.Record, b *rec.Record) (bool, error) {
+	if a.Sequence != b.Sequence {
+		return a.Sequence > b.Sequence, nil
+	}
+
+	ta, err := validUntil(a)
+	if err != nil {
+		return false, err
+	}
+	tb, err := validUntil(b)
+	if err != nil {
+		return false, err
+	}
+	if ta == nil {
+		return tb != nil, nil
+	}
+	return tb != nil && ta.After(*tb), nil
+}
+
+// Gets the time until which the record is valid, or nil if there is no
+// end to its validity
+func validUntil(r *rec.Record) (*time.Time, error) {
+	switch r.Validity.ValidationType {
+	case ld.ValidationType_EOL:
+		eol, err := rec.EolParseValidation(r)
+		if err != nil {
+			return nil, err
+		}
+		return &eol, nil
+	case ld.ValidationType_TimeRange:
+		rng, err := rec.RangeParseValidation(r)
+		if err != nil {
+			return nil, err
+		}
+		return rng[1], nil
+	}
+	return nil, fmt.Errorf("Unrecognized validation type %d", r.Validity.ValidationType)
+}
+
+// Gets the records currently published at the IPRS path that are
+// correctly signed, and that have not expired
+func (p *iprsPublisher) getCurrentRecords(ctx context.Context, iprsKey rsp.IprsPath) ([]*rec.Record, error) {
+	vals, err := p.vs.GetValues(ctx, iprsKey.String(), currentRecordCount)
+	if err == routing.ErrNotFound || err == ds.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		log.Warningf("Failed to retrieve current records at %s from value store: %s", iprsKey, err)
+		return nil, err
+	}
+
+	var recs []*rec.Record
+	for _, v := range vals {
+		r, err := p.getCurrentRecord(ctx, iprsKey, v.Val)
+		if err != nil {
+			log.Debugf("Ignoring current record at %s: %s", iprsKey, err)
+			continue
+		}
+		recs = append(recs, r)
+	}
+	return recs, nil
+}
+
+func (p *iprsPublisher) getCurrentRecord(ctx context.Context, iprsKey rsp.IprsPath, b []byte) (*rec.Record, error) {
+	recCid, err := cid.Cast(b)
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.dag.Get(ctx, recCid)
+	if err != nil {
+		return nil, err
+	}
+	iprsNode, err := ld.DecodeIprsBlock(n)
+	if err != nil {
+		return nil, err
+	}
+	r := rec.NewRecordFromNode(iprsNode)
+
+	if err = p.verifier.Verify(ctx, iprsKey, r); err != nil {
+		return nil, err
+	}
+	err = p.checker.ValidateRecord(ctx, iprsKey, r)
+	if err != nil && err != rec.ErrPendingRecord {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (p *iprsPublisher) publishNodes(nodes []node.Node) error {
 	batch := p.dag.Batch()
 	for _, n := range nodes {
 		if _, err := batch.Add(n); err != nil {
@@ -0,0 +1,280 @@
+package iprs_publisher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ld "github.com/dirkmc/go-iprs/ipld"
+	psh "github.com/dirkmc/go-iprs/publisher"
+	rec "github.com/dirkmc/go-iprs/record"
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	u "gx/ipfs/QmPsAfmDBnZN3kZGSuNwvCNDZiHneERSKmRcFyG3UkvcT3/go-ipfs-util"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestPublishChecksRecord(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	publisher := psh.NewDHTPublisher(r, dag)
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherpk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rec.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newRecord = func(s rec.RecordSigner, vl rec.RecordValidation) *rec.Record {
+		record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	// Expired records are refused
+	expired := newRecord(s, rec.NewEolRecordValidation(time.Now().Add(time.Hour*-1)))
+	err = publisher.Publish(ctx, iprsKey, expired)
+	if err != rec.ErrExpiredRecord {
+		t.Fatalf("Expected ErrExpiredRecord, got %v", err)
+	}
+
+	// Unsigned records are refused
+	validity := &ld.Validity{
+		VerificationType: ld.VerificationType_Key,
+		ValidationType:   ld.ValidationType_EOL,
+		Validation:       u.FormatRFC3339(time.Now().Add(time.Hour)),
+	}
+	n, err := ld.NewIprsNode(val.Bytes(), 0, validity, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publisher.Publish(ctx, iprsKey, rec.NewRecordFromNode(n))
+	if err != psh.ErrUnsignedRecord {
+		t.Fatalf("Expected ErrUnsignedRecord, got %v", err)
+	}
+
+	// Records signed with a key that doesn't match the path are refused
+	forged := newRecord(rec.NewKeyRecordSigner(otherpk), rec.NewEolRecordValidation(time.Now().Add(time.Hour)))
+	err = publisher.Publish(ctx, iprsKey, forged)
+	if err == nil {
+		t.Fatal("Failed to return error for record signed with a different key")
+	}
+
+	// Records must be published to a base path
+	valid := newRecord(s, rec.NewEolRecordValidation(time.Now().Add(time.Hour)))
+	subPath, err := s.BasePath("myrec/sub/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publisher.Publish(ctx, subPath, valid)
+	if err == nil {
+		t.Fatal("Failed to return error for publishing to a sub path")
+	}
+
+	// None of the refused records should have been published
+	_, err = r.GetValue(ctx, iprsKey.String())
+	if err == nil {
+		t.Fatal("Expected no record to have been published")
+	}
+
+	// Valid records are published
+	err = publisher.Publish(ctx, iprsKey, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.GetValue(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid.Cid().Equals(mustCast(t, b)) {
+		t.Fatal("Published record reference is incorrect")
+	}
+
+	// Records that are not yet valid can be published ahead of time
+	start := time.Now().Add(time.Hour)
+	rvl, err := rec.NewRangeRecordValidation(&start, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingKey, err := s.BasePath("pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publisher.Publish(ctx, pendingKey, newRecord(s, rvl))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishDoesNotOverwriteNewer(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	publisher := psh.NewDHTPublisher(r, dag)
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rec.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newRecord = func(eol time.Time, seq uint64) *rec.Record {
+		vl := rec.NewEolRecordValidation(eol)
+		record, err := rec.NewRecord(vl, s, val.Bytes(), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	// Checks which record is currently published
+	var checkPublished = func(record *rec.Record) {
+		b, err := r.GetValue(ctx, iprsKey.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !record.Cid().Equals(mustCast(t, b)) {
+			t.Fatal("Unexpected record published")
+		}
+	}
+
+	ts := time.Now()
+	current := newRecord(ts.Add(time.Hour), 1)
+	err = publisher.Publish(ctx, iprsKey, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Republishing the same record is fine
+	err = publisher.Publish(ctx, iprsKey, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A record with a lower sequence number is refused, even if it
+	// has a later EOL
+	lowerSeq := newRecord(ts.Add(time.Hour*2), 0)
+	err = publisher.Publish(ctx, iprsKey, lowerSeq)
+	conflict, ok := err.(*psh.RecordConflictError)
+	if !ok {
+		t.Fatalf("Expected RecordConflictError, got %v", err)
+	}
+	if !conflict.Current.Cid().Equals(current.Cid()) {
+		t.Fatal("Expected conflict error to contain the current record")
+	}
+	checkPublished(current)
+
+	// A record with the same sequence number and an earlier EOL is refused
+	earlierEol := newRecord(ts.Add(time.Minute*30), 1)
+	err = publisher.Publish(ctx, iprsKey, earlierEol)
+	if _, ok := err.(*psh.RecordConflictError); !ok {
+		t.Fatalf("Expected RecordConflictError, got %v", err)
+	}
+	checkPublished(current)
+
+	// Unless the Force option is set
+	err = publisher.PublishWithOpts(ctx, iprsKey, earlierEol, &psh.PublishOpts{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPublished(earlierEol)
+
+	// A newer record replaces the current record
+	newer := newRecord(ts.Add(time.Minute*10), 2)
+	err = publisher.Publish(ctx, iprsKey, newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPublished(newer)
+
+	// A record that is as new as the current record (same sequence number
+	// and EOL) but has a different value is not a conflict
+	val2, err := cid.Parse("/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tie, err := rec.NewRecord(rec.NewEolRecordValidation(ts.Add(time.Minute*10)), s, val2.Bytes(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publisher.Publish(ctx, iprsKey, tie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPublished(tie)
+
+	// Records with a different validation type are compared too
+	end := ts.Add(time.Hour * 2)
+	rangeRec := func(seq uint64) *rec.Record {
+		vl, err := rec.NewRangeRecordValidation(nil, &end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, err := rec.NewRecord(vl, s, val.Bytes(), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+	err = publisher.Publish(ctx, iprsKey, rangeRec(1))
+	if _, ok := err.(*psh.RecordConflictError); !ok {
+		t.Fatalf("Expected RecordConflictError, got %v", err)
+	}
+	checkPublished(tie)
+
+	rangeNewer := rangeRec(3)
+	err = publisher.Publish(ctx, iprsKey, rangeNewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPublished(rangeNewer)
+
+	err = publisher.Publish(ctx, iprsKey, newRecord(ts.Add(time.Hour*3), 2))
+	if _, ok := err.(*psh.RecordConflictError); !ok {
+		t.Fatalf("Expected RecordConflictError, got %v", err)
+	}
+	checkPublished(rangeNewer)
+}
+
+func mustCast(t *testing.T, b []byte) *cid.Cid {
+	c, err := cid.Cast(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
@@ -0,0 +1,285 @@
+package iprs_publisher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ld "github.com/dirkmc/go-iprs/ipld"
+	rsp "github.com/dirkmc/go-iprs/path"
+	rec "github.com/dirkmc/go-iprs/record"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+)
+
+// DefaultRepublishInterval is how often records are republished
+const DefaultRepublishInterval = time.Hour * 4
+
+// DefaultRecordLifetime is how long a re-signed EOL record is valid for
+const DefaultRecordLifetime = time.Hour * 24
+
+// RecordPublisher publishes records, eg a record system that also
+// invalidates its resolver's cache for the published path
+type RecordPublisher interface {
+	PublishWithOpts(ctx context.Context, iprsKey rsp.IprsPath, record *rec.Record, opts *PublishOpts) error
+}
+
+type republishEntry struct {
+	iprsKey rsp.IprsPath
+	record  *rec.Record
+	signer  rec.RecordSigner
+}
+
+// Republisher periodically republishes the records it is tracking, so that
+// the references to them in the value store don't age out.
+type Republisher struct {
+	pub *iprsPublisher
+	// Records are published through publisher
+	publisher RecordPublisher
+
+	// Interval is how often records are republished. If it is not
+	// positive, DefaultRepublishInterval is used.
+	Interval time.Duration
+	// RecordLifetime is how long a re-signed EOL record is valid for
+	RecordLifetime time.Duration
+
+	lk      sync.Mutex
+	entries map[string]*republishEntry
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewRepublisher creates a republisher for the IPFS Routing name system.
+// Call Start to begin republishing.
+func NewRepublisher(vs routing.ValueStore, dag mdag.DAGService) *Republisher {
+	return NewRepublisherWithPublisher(vs, dag, nil)
+}
+
+// NewRepublisherWithPublisher creates a republisher that publishes records
+// (including re-signed records) through the given publisher, eg so that
+// the record system's resolver cache is invalidated. If p is nil records
+// are published directly to the value store.
+func NewRepublisherWithPublisher(vs routing.ValueStore, dag mdag.DAGService, p RecordPublisher) *Republisher {
+	pub := NewDHTPublisher(vs, dag)
+	if p == nil {
+		p = pub
+	}
+	return &Republisher{
+		pub:            pub,
+		publisher:      p,
+		Interval:       DefaultRepublishInterval,
+		RecordLifetime: DefaultRecordLifetime,
+		entries:        make(map[string]*republishEntry),
+	}
+}
+
+// Publish implements Publisher. Publishes the record and tracks it so that
+// it will be republished
+func (rp *Republisher) Publish(ctx context.Context, iprsKey rsp.IprsPath, record *rec.Record) error {
+	return rp.PublishWithOpts(ctx, iprsKey, record, nil)
+}
+
+// PublishWithOpts implements Publisher
+func (rp *Republisher) PublishWithOpts(ctx context.Context, iprsKey rsp.IprsPath, record *rec.Record, opts *PublishOpts) error {
+	err := rp.publisher.PublishWithOpts(ctx, iprsKey, record, opts)
+	if err != nil {
+		return err
+	}
+	rp.Track(iprsKey, record, nil)
+	return nil
+}
+
+// Track adds a record to the set of records that are republished.
+// If signer is not nil, EOL records are re-signed with the signer and
+// published with a new EOL of RecordLifetime from now before they expire.
+// Tracking a record replaces any record tracked for the same IPRS path.
+func (rp *Republisher) Track(iprsKey rsp.IprsPath, record *rec.Record, signer rec.RecordSigner) {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+
+	rp.entries[iprsKey.String()] = &republishEntry{iprsKey, record, signer}
+}
+
+// Untrack stops republishing the record at the given IPRS path
+func (rp *Republisher) Untrack(iprsKey rsp.IprsPath) {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+
+	delete(rp.entries, iprsKey.String())
+}
+
+// Start republishing every Interval until the context is cancelled or
+// Stop is called
+func (rp *Republisher) Start(ctx context.Context) {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+
+	if rp.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	rp.cancel = cancel
+	rp.done = done
+	go rp.run(ctx, done)
+}
+
+// Stop republishing and wait for any republish in progress to finish
+func (rp *Republisher) Stop() {
+	rp.lk.Lock()
+	cancel := rp.cancel
+	done := rp.done
+	rp.cancel = nil
+	rp.done = nil
+	rp.lk.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (rp *Republisher) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(rp.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := rp.Republish(ctx); err != nil {
+				log.Warningf("Republish failed: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Republish republishes all tracked records once. If there are errors
+// it carries on with the remaining records and returns the last error.
+func (rp *Republisher) Republish(ctx context.Context) error {
+	rp.lk.Lock()
+	entries := make([]*republishEntry, 0, len(rp.entries))
+	for _, e := range rp.entries {
+		entries = append(entries, e)
+	}
+	rp.lk.Unlock()
+
+	var lastErr error
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := rp.republishEntry(ctx, e); err != nil {
+			log.Warningf("Failed to republish %s: %s", e.iprsKey, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (rp *Republisher) republishEntry(ctx context.Context, e *republishEntry) error {
+	log.Debugf("Republish %s", e.iprsKey)
+
+	// If someone else has published a different record since this one
+	// was tracked, stop republishing it rather than rolling back their
+	// update
+	cur, err := rp.currentRecord(ctx, e.iprsKey)
+	if err != nil {
+		return err
+	}
+	if cur != nil && !cur.Cid().Equals(e.record.Cid()) && cur.Sequence >= e.record.Sequence {
+		log.Warningf("Record [%s] has been published at %s since [%s] was tracked, no longer republishing it", cur.Cid(), e.iprsKey, e.record.Cid())
+		rp.untrackEntry(e)
+		return nil
+	}
+
+	// The tracked record is now either the current record, or newer than
+	// the records in the value store (eg because they have aged out)
+	record := e.record
+	renew, err := rp.needsRenewal(e)
+	if err != nil {
+		return err
+	}
+	if renew {
+		if err = rec.CheckSigner(e.iprsKey, record, e.signer); err != nil {
+			return err
+		}
+		vl := rec.NewEolRecordValidation(time.Now().Add(rp.RecordLifetime))
+		record, err = rec.NewRecord(vl, e.signer, record.Value, record.Sequence+1)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Re-signed %s with sequence number %d", e.iprsKey, record.Sequence)
+	} else if rec.MasterRecordChecker.ValidateRecord(ctx, e.iprsKey, record) == rec.ErrExpiredRecord {
+		// A record that has expired and can't be re-signed will never
+		// be valid again, so stop republishing it
+		log.Warningf("Record [%s] at %s has expired, no longer republishing it", record.Cid(), e.iprsKey)
+		rp.untrackEntry(e)
+		return nil
+	}
+
+	err = rp.publisher.PublishWithOpts(ctx, e.iprsKey, record, nil)
+	if err != nil {
+		return err
+	}
+
+	if renew {
+		// Replace the tracked record, unless it was replaced while
+		// we were republishing
+		rp.lk.Lock()
+		if cur, ok := rp.entries[e.iprsKey.String()]; ok && cur == e {
+			rp.entries[e.iprsKey.String()] = &republishEntry{e.iprsKey, record, e.signer}
+		}
+		rp.lk.Unlock()
+	}
+
+	return nil
+}
+
+// Gets the newest record currently published at the IPRS path, or nil if
+// there is none
+func (rp *Republisher) currentRecord(ctx context.Context, iprsKey rsp.IprsPath) (*rec.Record, error) {
+	timectx, cancel := context.WithTimeout(ctx, CurrentRecordTimeout)
+	defer cancel()
+
+	current, err := rp.pub.getCurrentRecords(timectx, iprsKey)
+	if err != nil {
+		return nil, err
+	}
+	return newestRecord(current)
+}
+
+// Stops republishing the entry, unless it has already been replaced
+func (rp *Republisher) untrackEntry(e *republishEntry) {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+
+	if cur, ok := rp.entries[e.iprsKey.String()]; ok && cur == e {
+		delete(rp.entries, e.iprsKey.String())
+	}
+}
+
+// An EOL record is re-signed if it would expire before the republish
+// after next
+func (rp *Republisher) needsRenewal(e *republishEntry) (bool, error) {
+	if e.signer == nil || e.record.Validity.ValidationType != ld.ValidationType_EOL {
+		return false, nil
+	}
+	eol, err := rec.EolParseValidation(e.record)
+	if err != nil {
+		return false, err
+	}
+	return eol.Before(time.Now().Add(rp.interval() * 2)), nil
+}
+
+func (rp *Republisher) interval() time.Duration {
+	if rp.Interval <= 0 {
+		return DefaultRepublishInterval
+	}
+	return rp.Interval
+}
@@ -0,0 +1,366 @@
+package iprs_publisher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ld "github.com/dirkmc/go-iprs/ipld"
+	rsp "github.com/dirkmc/go-iprs/path"
+	psh "github.com/dirkmc/go-iprs/publisher"
+	rec "github.com/dirkmc/go-iprs/record"
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	u "gx/ipfs/QmPsAfmDBnZN3kZGSuNwvCNDZiHneERSKmRcFyG3UkvcT3/go-ipfs-util"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestRepublish(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	rp := psh.NewRepublisher(r, dag)
+	rp.Interval = time.Minute
+	rp.RecordLifetime = time.Hour
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rec.NewKeyRecordSigner(pk)
+
+	val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newRecord = func(eol time.Time) *rec.Record {
+		vl := rec.NewEolRecordValidation(eol)
+		record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	// Gets the record currently referenced by the value store
+	var getRecord = func(iprsKey rsp.IprsPath) *ld.Node {
+		b, err := r.GetValue(ctx, iprsKey.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		recCid, err := cid.Cast(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := dag.Get(ctx, recCid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iprsNode, err := ld.DecodeIprsBlock(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iprsNode
+	}
+
+	// Published record with a long EOL, and no signer
+	longKey, err := s.BasePath("long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := newRecord(time.Now().Add(time.Hour * 24))
+	err = rp.Publish(ctx, longKey, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record that is about to expire, tracked with a signer
+	shortKey, err := s.BasePath("short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	short := newRecord(time.Now().Add(time.Second * 30))
+	err = psh.NewDHTPublisher(r, dag).Publish(ctx, shortKey, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.Track(shortKey, short, s)
+
+	// Remove the references from the value store
+	for _, k := range []rsp.IprsPath{longKey, shortKey} {
+		if err = r.DeleteValue(k.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The record with a long EOL should be republished as is
+	if !getRecord(longKey).Cid().Equals(long.Cid()) {
+		t.Fatal("Expected record to be republished unchanged")
+	}
+
+	// The record that was about to expire should be re-signed with
+	// a later EOL and a higher sequence number
+	renewed := rec.NewRecordFromNode(getRecord(shortKey))
+	if renewed.Sequence != short.Sequence+1 {
+		t.Fatalf("Expected sequence %d, got %d", short.Sequence+1, renewed.Sequence)
+	}
+	eol, err := rec.EolParseValidation(renewed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eol.Before(time.Now().Add(time.Minute * 59)) {
+		t.Fatalf("Expected EOL to be extended, got %s", eol)
+	}
+	err = rec.NewKeyRecordVerifier(rec.NewPublicKeyManager(dag)).VerifyRecord(ctx, shortKey, renewed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Republishing again doesn't re-sign the renewed record
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !getRecord(shortKey).Cid().Equals(renewed.Cid()) {
+		t.Fatal("Expected renewed record to be republished unchanged")
+	}
+
+	// Untracked records are not republished
+	rp.Untrack(longKey)
+	if err = r.DeleteValue(longKey.String()); err != nil {
+		t.Fatal(err)
+	}
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.GetValue(ctx, longKey.String())
+	if err == nil {
+		t.Fatal("Expected untracked record not to be republished")
+	}
+}
+
+func TestRepublishDoesNotRollBackNewerRecord(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	rp := psh.NewRepublisher(r, dag)
+	rp.Interval = time.Minute
+	rp.RecordLifetime = time.Hour
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rec.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newRecord = func(p string, seq uint64) *rec.Record {
+		val, err := cid.Parse(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vl := rec.NewEolRecordValidation(time.Now().Add(time.Second * 30))
+		record, err := rec.NewRecord(vl, s, val.Bytes(), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	// Publish a record that is about to expire, tracked with a signer
+	tracked := newRecord("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN", 0)
+	err = psh.NewDHTPublisher(r, dag).Publish(ctx, iprsKey, tracked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.Track(iprsKey, tracked, s)
+
+	// Another writer publishes a new value with a higher sequence number
+	other := newRecord("/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy", 1)
+	err = psh.NewDHTPublisher(r, dag).Publish(ctx, iprsKey, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The republisher should not renew the old value over the new one
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.GetValue(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cid.Cast(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equals(other.Cid()) {
+		t.Fatal("Expected the other writer's record to remain published")
+	}
+
+	// And should have stopped tracking the record
+	if err = r.DeleteValue(iprsKey.String()); err != nil {
+		t.Fatal(err)
+	}
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.GetValue(ctx, iprsKey.String())
+	if err == nil {
+		t.Fatal("Expected replaced record to be untracked")
+	}
+}
+
+func TestRepublisherStartStop(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	rp := psh.NewRepublisher(r, dag)
+	rp.Interval = time.Millisecond * 50
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rec.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+	record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rp.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.DeleteValue(iprsKey.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	rp.Start(ctx)
+	defer rp.Stop()
+
+	// Wait for the record to be republished
+	timeout := time.After(time.Second * 5)
+	for {
+		_, err = r.GetValue(ctx, iprsKey.String())
+		if err == nil {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("Timed out waiting for record to be republished")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+
+	// Once stopped, records are no longer republished
+	rp.Stop()
+	if err = r.DeleteValue(iprsKey.String()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(rp.Interval * 3)
+	_, err = r.GetValue(ctx, iprsKey.String())
+	if err == nil {
+		t.Fatal("Expected record not to be republished after Stop")
+	}
+}
+
+func TestRepublishExpiredRecord(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	rp := psh.NewRepublisher(r, dag)
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rec.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vl := rec.NewEolRecordValidation(time.Now().Add(time.Millisecond * 100))
+	record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rp.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.DeleteValue(iprsKey.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the record has expired it can't be re-signed without a signer,
+	// so it should be untracked rather than failing to republish
+	time.Sleep(time.Millisecond * 200)
+	for i := 0; i < 2; i++ {
+		err = rp.Republish(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = r.GetValue(ctx, iprsKey.String())
+	if err == nil {
+		t.Fatal("Expected expired record not to be republished")
+	}
+}
+
+func TestRepublisherInvalidInterval(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	rp := psh.NewRepublisher(r, dag)
+
+	// A non-positive interval falls back to the default rather than
+	// panicking
+	rp.Interval = 0
+	rp.Start(ctx)
+	rp.Stop()
+}
@@ -1,10 +1,13 @@
 package iprs_record
 
 import (
+	"bytes"
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"time"
 
 	c "github.com/dirkmc/go-iprs/certificate"
 	ld "github.com/dirkmc/go-iprs/ipld"
@@ -13,18 +16,39 @@ import (
 	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
 )
 
+// MaxCertChainLength is the maximum number of certificates in a record's
+// certificate chain (not including the certificate in the IPRS path)
+const MaxCertChainLength = 8
+
+// ErrCertChainTooLong is returned when a record's certificate chain
+// has more than MaxCertChainLength certificates
+var ErrCertChainTooLong = errors.New("Certificate chain is too long")
+
 type CertRecordSigner struct {
-	cert     *x509.Certificate
-	pk       *rsa.PrivateKey
-	certNode node.Node
+	cert          *x509.Certificate
+	pk            crypto.Signer
+	intermediates []*x509.Certificate
+	certNodes     []node.Node
 }
 
-// TODO: Include a whitelist of certificates that are allowed to change IPRS records
-// under the issuing certificate's path (so that permission can be revoked)
-func NewCertRecordSigner(cert *x509.Certificate, pk *rsa.PrivateKey) *CertRecordSigner {
+// The private key can be an RSA, ECDSA or Ed25519 key
+// (eg *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey)
+//
+// If the certificate was not issued directly by the CA certificate whose
+// CID is in the IPRS path, the intermediate certificates should be
+// supplied in order, starting with the issuer of the signing certificate,
+// eg for the chain CA -> team lead -> developer:
+//
+//	NewCertRecordSigner(developerCert, developerPk, teamLeadCert)
+//
+// The CA certificate owner can restrict a child certificate to particular
+// IDs with the extension created by certificate.NewIdConstraintsExtension,
+// and can revoke permission for child certificates with NewRevocationRecord
+func NewCertRecordSigner(cert *x509.Certificate, pk crypto.Signer, intermediates ...*x509.Certificate) *CertRecordSigner {
 	return &CertRecordSigner{
-		cert: cert,
-		pk:   pk,
+		cert:          cert,
+		pk:            pk,
+		intermediates: intermediates,
 	}
 }
 
@@ -32,55 +56,71 @@ func (s *CertRecordSigner) VerificationType() ld.IprsVerificationType {
 	return ld.VerificationType_Cert
 }
 
-// Cache the Certificate node
-func (s *CertRecordSigner) getCertNode() (node.Node, error) {
-	if s.certNode != nil {
-		return s.certNode, nil
+// Cache the Certificate nodes (signing certificate followed by
+// any intermediate certificates)
+func (s *CertRecordSigner) getCertNodes() ([]node.Node, error) {
+	if s.certNodes != nil {
+		return s.certNodes, nil
 	}
 
-	b, err := c.MarshalCertificate(s.cert)
-	if err != nil {
-		return nil, err
+	certs := append([]*x509.Certificate{s.cert}, s.intermediates...)
+	nodes := make([]node.Node, 0, len(certs))
+	for _, cert := range certs {
+		b, err := c.MarshalCertificate(cert)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, ld.Certificate(b))
 	}
-	s.certNode = ld.Certificate(b)
+	s.certNodes = nodes
 
-	return s.certNode, nil
+	return s.certNodes, nil
 }
 
 func (s *CertRecordSigner) Nodes() ([]node.Node, error) {
-	n, err := s.getCertNode()
-	if err != nil {
-		return nil, err
-	}
-	return []node.Node{n}, nil
+	return s.getCertNodes()
 }
 
 func (s *CertRecordSigner) BasePath(id string) (rsp.IprsPath, error) {
-	n, err := s.getCertNode()
+	nodes, err := s.getCertNodes()
 	if err != nil {
 		return rsp.NilPath, err
 	}
-	return rsp.FromString("/iprs/" + n.Cid().String() + "/" + id)
+	return rsp.FromString("/iprs/" + nodes[0].Cid().String() + "/" + id)
 }
 
 func (s *CertRecordSigner) SignRecord(data []byte) ([]byte, error) {
 	return c.Sign(s.pk, data)
 }
 
+// Verification data is the CID of the signing certificate, or if there
+// are intermediate certificates, the chain of certificate CIDs
 func (s *CertRecordSigner) Verification() (interface{}, error) {
-	n, err := s.getCertNode()
+	nodes, err := s.getCertNodes()
 	if err != nil {
 		return nil, err
 	}
-	return n.Cid(), nil
+	if len(nodes) == 1 {
+		return nodes[0].Cid(), nil
+	}
+
+	chain := make([]*cid.Cid, len(nodes))
+	for i, n := range nodes {
+		chain[i] = n.Cid()
+	}
+	return chain, nil
 }
 
 func prepareCertSig(o interface{}) ([]byte, error) {
-	c, err := toCid(o)
+	chain, err := toCidChain(o)
 	if err != nil {
 		return nil, err
 	}
-	return c.Bytes(), nil
+	b := make([][]byte, len(chain))
+	for i, c := range chain {
+		b[i] = c.Bytes()
+	}
+	return bytes.Join(b, []byte{}), nil
 }
 
 // The CBOR encoder encodes CIDs as links, so depending on where we
@@ -97,35 +137,142 @@ func toCid(o interface{}) (*cid.Cid, error) {
 	return nil, fmt.Errorf("Unrecognized verification data type %T. Expected Link or Cid", o)
 }
 
+// Verification data may be a single CID or an array of CIDs
+func toCidChain(o interface{}) ([]*cid.Cid, error) {
+	switch v := o.(type) {
+	case []*cid.Cid:
+		return v, nil
+	case []interface{}:
+		chain := make([]*cid.Cid, len(v))
+		for i, e := range v {
+			c, err := toCid(e)
+			if err != nil {
+				return nil, err
+			}
+			chain[i] = c
+		}
+		return chain, nil
+	}
+
+	c, err := toCid(o)
+	if err != nil {
+		return nil, err
+	}
+	return []*cid.Cid{c}, nil
+}
+
 type CertRecordVerifier struct {
-	m *c.CertificateManager
+	m  *c.CertificateManager
+	rm *RevocationManager
 }
 
 func NewCertRecordVerifier(m *c.CertificateManager) *CertRecordVerifier {
-	return &CertRecordVerifier{m}
+	return &CertRecordVerifier{m: m}
+}
+
+// NewRevocationCheckingCertRecordVerifier creates a verifier that also
+// rejects records signed by certificates that the CA has revoked
+func NewRevocationCheckingCertRecordVerifier(m *c.CertificateManager, rm *RevocationManager) *CertRecordVerifier {
+	return &CertRecordVerifier{m, rm}
 }
 
 func (v *CertRecordVerifier) VerifyRecord(ctx context.Context, iprsKey rsp.IprsPath, record *Record) error {
-	certCid, err := toCid(record.Validity.Verification)
+	chainCids, err := toCidChain(record.Validity.Verification)
 	if err != nil {
 		return err
 	}
-	issuerCertCid := iprsKey.Cid()
+	if len(chainCids) == 0 {
+		return fmt.Errorf("Empty certificate chain for record at [%s]", iprsKey)
+	}
+	if len(chainCids) > MaxCertChainLength {
+		return ErrCertChainTooLong
+	}
+	certCid := chainCids[0]
+	rootCertCid := iprsKey.Cid()
+
+	// The issuer can use her own cert to sign records
+	selfSigned := len(chainCids) == 1 && certCid.Equals(rootCertCid)
+
+	// Only the CA certificate can publish the revocation list, otherwise
+	// a delegated certificate could replace it with an empty list
+	if iprsKey.Id() == RevocationListId && !selfSigned {
+		log.Warningf("Revocation list at [%s] is not signed by CA cert [%s]", iprsKey, rootCertCid)
+		return ErrRevocationListSigner
+	}
+
+	if !selfSigned {
+		chainCids = append(chainCids, rootCertCid)
+	}
 
 	// Hashes should be X509 certificates retrievable from ipfs
-	cert, issuerCert, err := v.getCerts(ctx, certCid, issuerCertCid)
+	certs, err := v.getCerts(ctx, chainCids)
 	if err != nil {
 		return err
 	}
+	cert := certs[0]
+
+	// Check that the certificates are currently valid
+	now := time.Now()
+	for i, ct := range certs {
+		if err = c.CheckValidityPeriod(ct, now); err != nil {
+			log.Warningf("Cert [%s] is not valid at %s: %v", chainCids[i], now, err)
+			return err
+		}
+	}
+
+	// Walk the chain from the signing certificate up to the certificate
+	// in the IPRS path, checking that each certificate was issued by
+	// the next certificate in the chain
+	for i := 0; i < len(certs)-1; i++ {
+		issuer := certs[i+1]
+
+		// Check that the issuer is allowed to issue certificates
+		if err = c.CheckIsCA(issuer); err != nil {
+			log.Warningf("Issuer cert [%s] is not a CA certificate", chainCids[i+1])
+			return err
+		}
+
+		// Check that the number of intermediate certificates below the
+		// issuer does not exceed the issuer's maximum path length
+		if err = c.CheckMaxPathLen(issuer, i); err != nil {
+			log.Warningf("Issuer cert [%s] has %d intermediate certs below it, exceeding its max path length", chainCids[i+1], i)
+			return err
+		}
+
+		// Check that issuer issued the certificate
+		if err = c.CheckSignatureFrom(certs[i], issuer); err != nil {
+			log.Warningf("Check signature parent failed for cert [%s] issued by cert [%s]: %v", chainCids[i], chainCids[i+1], err)
+			return err
+		}
+	}
+
+	// Check that each certificate below the CA certificate is permitted
+	// to sign records for this ID
+	if !selfSigned {
+		for i, ct := range certs[:len(certs)-1] {
+			if err = c.CheckIdConstraints(ct, iprsKey.Id()); err != nil {
+				log.Warningf("Cert [%s] is not permitted to sign records for ID %s: %v", chainCids[i], iprsKey.Id(), err)
+				return err
+			}
+		}
+	}
+
+	// Check that none of the certificates below the CA certificate
+	// have been revoked
+	if v.rm != nil && !selfSigned {
+		if err = v.rm.CheckRevoked(ctx, rootCertCid, certs); err != nil {
+			return err
+		}
+	}
 
-	// Check that issuer issued the certificate
-	if err = c.CheckSignatureFrom(cert, issuerCert); err != nil {
-		log.Warningf("Check signature parent failed for cert [%s] issued by cert [%s]: %v", certCid, issuerCertCid, err)
+	// Check that the certificate is allowed to sign records
+	if err = c.CheckDigitalSignatureUsage(cert); err != nil {
+		log.Warningf("Cert [%s] does not have digital signature key usage", certCid)
 		return err
 	}
 
 	// Check signature with certificate
-	sigd, err := dataForSig(record.Value, record.Validity)
+	sigd, err := dataForSig(record.Version, record.Value, record.Sequence, record.Validity)
 	if err != nil {
 		return fmt.Errorf("Failed to marshall data for signature for cert [%s]: %v", certCid, err)
 	}
@@ -137,25 +284,15 @@ func (v *CertRecordVerifier) VerifyRecord(ctx context.Context, iprsKey rsp.IprsP
 	return nil
 }
 
-func (v *CertRecordVerifier) getCerts(ctx context.Context, certCid, issuerCertCid *cid.Cid) (*x509.Certificate, *x509.Certificate, error) {
-	// The issuer can use her own cert to sign records
-	if certCid.Equals(issuerCertCid) {
-		ct, err := v.m.GetCertificate(ctx, certCid)
-		if err != nil {
-			log.Warningf("Failed to get Certificate [%s]", certCid)
-			return nil, nil, err
-		}
-		return ct, ct, nil
-	}
-
-	// If the issuer and cert cids are different, get them in parallel
-	var cert, issuerCert *x509.Certificate
-	resp := make(chan error, 2)
+// Get the certificates with the given CIDs in parallel
+func (v *CertRecordVerifier) getCerts(ctx context.Context, cids []*cid.Cid) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, len(cids))
+	resp := make(chan error, len(cids))
 
-	getCert := func(cid *cid.Cid, cType string, cPtr **x509.Certificate) {
+	getCert := func(cid *cid.Cid, cPtr **x509.Certificate) {
 		ct, err := v.m.GetCertificate(ctx, cid)
 		if err != nil {
-			log.Warningf("Failed to get %s [%s]", cType, cid)
+			log.Warningf("Failed to get Certificate [%s]", cid)
 			resp <- err
 			return
 		}
@@ -164,18 +301,19 @@ func (v *CertRecordVerifier) getCerts(ctx context.Context, certCid, issuerCertCi
 		resp <- nil
 	}
 
-	go getCert(certCid, "Certificate", &cert)
-	go getCert(issuerCertCid, "Issuer Certificate", &issuerCert)
+	for i, cid := range cids {
+		go getCert(cid, &certs[i])
+	}
 
 	var err error
-	for i := 0; i < 2; i++ {
+	for i := 0; i < len(cids); i++ {
 		err = <-resp
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 	}
 
-	return cert, issuerCert, nil
+	return certs, nil
 }
 
 func init() {
@@ -2,6 +2,7 @@ package iprs_record_test
 
 import (
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -9,10 +10,12 @@ import (
 	"time"
 
 	c "github.com/dirkmc/go-iprs/certificate"
+	ld "github.com/dirkmc/go-iprs/ipld"
 	rsp "github.com/dirkmc/go-iprs/path"
 	psh "github.com/dirkmc/go-iprs/publisher"
 	rec "github.com/dirkmc/go-iprs/record"
 	tu "github.com/dirkmc/go-iprs/test"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
 	dstest "github.com/ipfs/go-ipfs/merkledag/test"
 	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
 	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
@@ -42,7 +45,7 @@ func TestCertRecordVerification(t *testing.T) {
 		}
 		vl := rec.NewEolRecordValidation(eol)
 		s := rec.NewCertRecordSigner(cert, pk)
-		rec, err := rec.NewRecord(vl, s, c.Bytes())
+		rec, err := rec.NewRecord(vl, s, c.Bytes(), 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -118,7 +121,15 @@ func TestCertRecordVerification(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	e3 := publishNewRecord(childCertIprsKey, unrelatedPk, childCert, ts.Add(time.Hour))
+	val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vl := rec.NewEolRecordValidation(ts.Add(time.Hour))
+	e3, err := rec.NewRecord(vl, rec.NewCertRecordSigner(childCert, unrelatedPk), val.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	err = verifier.VerifyRecord(ctx, childCertIprsKey, e3)
 	if err == nil {
@@ -202,6 +213,387 @@ func TestCertRecordVerification(t *testing.T) {
 	*/
 }
 
+func TestCertRecordVerificationKeyTypes(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(context.Background(), id, dstore)
+	certManager := c.NewCertificateManager(dag)
+	verifier := rec.NewCertRecordVerifier(certManager)
+	publisher := psh.NewDHTPublisher(r, dag)
+
+	val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		caType  tu.KeyType
+		keyType tu.KeyType
+	}{
+		{"ECDSA", tu.ECDSA, tu.ECDSA},
+		{"Ed25519", tu.Ed25519, tu.Ed25519},
+		{"RSA CA with ECDSA child", tu.RSA, tu.ECDSA},
+		{"ECDSA CA with Ed25519 child", tu.ECDSA, tu.Ed25519},
+	}
+
+	for _, test := range tests {
+		caCert, caPk, err := tu.GenerateCACertificateWithKeyType("ca cert", test.caType)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		childCert, childPk, err := tu.GenerateChildCertificateWithKeyType("child cert", test.keyType, caCert, caPk)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		iprsKey := getIprsPathFromCert(t, caCert, caPk, "myrec")
+
+		for _, s := range []*rec.CertRecordSigner{
+			rec.NewCertRecordSigner(caCert, caPk),
+			rec.NewCertRecordSigner(childCert, childPk),
+		} {
+			vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+			record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			err = publisher.Publish(ctx, iprsKey, record)
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+
+			err = verifier.VerifyRecord(ctx, iprsKey, record)
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+
+			// Tampering with the signature should cause verification to fail
+			tampered := *record
+			tampered.Signature = append([]byte{}, record.Signature...)
+			tampered.Signature[len(tampered.Signature)-1] ^= 0xff
+			err = verifier.VerifyRecord(ctx, iprsKey, &tampered)
+			if err == nil {
+				t.Fatalf("%s: Failed to return error for tampered signature", test.name)
+			}
+		}
+	}
+}
+
+func TestCertRecordVerificationConstraints(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	certManager := c.NewCertificateManager(dag)
+	verifier := rec.NewCertRecordVerifier(certManager)
+
+	// Creates a record signed by the certificate, adds its nodes
+	// to the DAG and verifies it under the issuer's path
+	var verifyNewRecord = func(issuer *x509.Certificate, issuerPk crypto.Signer, cert *x509.Certificate, pk crypto.Signer) error {
+		val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+		s := rec.NewCertRecordSigner(cert, pk)
+		record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iprsKey := getIprsPathFromCert(t, issuer, issuerPk, "myrec")
+		addCertToDag(t, dag, issuer)
+		addRecordNodesToDag(t, dag, record)
+		return verifier.VerifyRecord(ctx, iprsKey, record)
+	}
+
+	// Generates a certificate from a template that is modified by the
+	// given function
+	var generateCert = func(parent *x509.Certificate, parentPk crypto.Signer, isCA bool, modify func(*x509.Certificate)) (*x509.Certificate, crypto.Signer) {
+		template, err := tu.NewCertificate("cert")
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.IsCA = isCA
+		if isCA {
+			template.KeyUsage |= x509.KeyUsageCertSign
+		}
+		modify(template)
+		pk, err := tu.GenerateKey(tu.RSA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := tu.GenerateCertificateFromTemplate(template, pk, parent, parentPk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, pk
+	}
+	var noChange = func(*x509.Certificate) {}
+
+	now := time.Now()
+	caCert, caPk := generateCert(nil, nil, true, noChange)
+
+	// Sanity check
+	childCert, childPk := generateCert(caCert, caPk, false, noChange)
+	err := verifyNewRecord(caCert, caPk, childCert, childPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired child certificate
+	expiredCert, expiredPk := generateCert(caCert, caPk, false, func(tmpl *x509.Certificate) {
+		tmpl.NotBefore = now.Add(time.Hour * -2)
+		tmpl.NotAfter = now.Add(time.Hour * -1)
+	})
+	err = verifyNewRecord(caCert, caPk, expiredCert, expiredPk)
+	if err != c.CertificateExpiredError {
+		t.Fatalf("Expected CertificateExpiredError, got %v", err)
+	}
+
+	// Child certificate that is not yet valid
+	pendingCert, pendingPk := generateCert(caCert, caPk, false, func(tmpl *x509.Certificate) {
+		tmpl.NotBefore = now.Add(time.Hour)
+		tmpl.NotAfter = now.Add(time.Hour * 2)
+	})
+	err = verifyNewRecord(caCert, caPk, pendingCert, pendingPk)
+	if err != c.CertificateNotYetValidError {
+		t.Fatalf("Expected CertificateNotYetValidError, got %v", err)
+	}
+
+	// Expired CA certificate
+	expiredCaCert, expiredCaPk := generateCert(nil, nil, true, func(tmpl *x509.Certificate) {
+		tmpl.NotBefore = now.Add(time.Hour * -2)
+		tmpl.NotAfter = now.Add(time.Hour * -1)
+	})
+	expiredCaChildCert, expiredCaChildPk := generateCert(expiredCaCert, expiredCaPk, false, noChange)
+	err = verifyNewRecord(expiredCaCert, expiredCaPk, expiredCaChildCert, expiredCaChildPk)
+	if err != c.CertificateExpiredError {
+		t.Fatalf("Expected CertificateExpiredError, got %v", err)
+	}
+
+	// Issuer that is not a CA
+	notCaCert, notCaPk := generateCert(nil, nil, false, noChange)
+	notCaChildCert, notCaChildPk := generateCert(notCaCert, notCaPk, false, noChange)
+	err = verifyNewRecord(notCaCert, notCaPk, notCaChildCert, notCaChildPk)
+	if err != c.IssuerNotCAError {
+		t.Fatalf("Expected IssuerNotCAError, got %v", err)
+	}
+
+	// A certificate that is not a CA can still sign records under its own path
+	err = verifyNewRecord(notCaCert, notCaPk, notCaCert, notCaPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Child certificate without digital signature key usage
+	noSigCert, noSigPk := generateCert(caCert, caPk, false, func(tmpl *x509.Certificate) {
+		tmpl.KeyUsage = x509.KeyUsageKeyEncipherment
+	})
+	err = verifyNewRecord(caCert, caPk, noSigCert, noSigPk)
+	if err != c.CertificateKeyUsageError {
+		t.Fatalf("Expected CertificateKeyUsageError, got %v", err)
+	}
+}
+
+func TestCertRecordVerificationChain(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	certManager := c.NewCertificateManager(dag)
+	verifier := rec.NewCertRecordVerifier(certManager)
+
+	// Creates a record with the signer, adds its nodes
+	// to the DAG and verifies it under the CA's path
+	var verifyNewRecord = func(iprsKey rsp.IprsPath, s *rec.CertRecordSigner) error {
+		val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+		record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addRecordNodesToDag(t, dag, record)
+		return verifier.VerifyRecord(ctx, iprsKey, record)
+	}
+
+	// Alice's CA certificate
+	caCert, caPk, err := tu.GenerateCACertificate("alice ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey := getIprsPathFromCert(t, caCert, caPk, "shared")
+	addCertToDag(t, dag, caCert)
+
+	// Alice issues an intermediate CA certificate to the team lead
+	leadCert, leadPk, err := tu.GenerateCertificate("team lead cert", caCert, caPk, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The team lead issues a certificate to a developer
+	devCert, devPk, err := tu.GenerateChildCertificate("developer cert", leadCert, leadPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The team lead can sign records directly
+	err = verifyNewRecord(iprsKey, rec.NewCertRecordSigner(leadCert, leadPk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The developer can sign records by including the chain
+	err = verifyNewRecord(iprsKey, rec.NewCertRecordSigner(devCert, devPk, leadCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the intermediate certificate the chain is broken
+	err = verifyNewRecord(iprsKey, rec.NewCertRecordSigner(devCert, devPk))
+	if err != c.CertificateIssuerError {
+		t.Fatalf("Expected CertificateIssuerError, got %v", err)
+	}
+
+	// The chain must lead back to the CA in the IPRS path
+	unrelatedCaCert, unrelatedCaPk, err := tu.GenerateCACertificate("unrelated ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrelatedIprsKey := getIprsPathFromCert(t, unrelatedCaCert, unrelatedCaPk, "shared")
+	addCertToDag(t, dag, unrelatedCaCert)
+	err = verifyNewRecord(unrelatedIprsKey, rec.NewCertRecordSigner(devCert, devPk, leadCert))
+	if err != c.CertificateIssuerError {
+		t.Fatalf("Expected CertificateIssuerError, got %v", err)
+	}
+
+	// Intermediate certificates must be CA certificates
+	notCaLeadCert, notCaLeadPk, err := tu.GenerateChildCertificate("not ca team lead cert", caCert, caPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notCaDevCert, notCaDevPk, err := tu.GenerateChildCertificate("developer cert", notCaLeadCert, notCaLeadPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyNewRecord(iprsKey, rec.NewCertRecordSigner(notCaDevCert, notCaDevPk, notCaLeadCert))
+	if err != c.IssuerNotCAError {
+		t.Fatalf("Expected IssuerNotCAError, got %v", err)
+	}
+
+	// A CA with a max path length of zero cannot have intermediates
+	tmpl, err := tu.NewCertificate("max path len zero ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.IsCA = true
+	tmpl.KeyUsage |= x509.KeyUsageCertSign
+	tmpl.MaxPathLen = 0
+	tmpl.MaxPathLenZero = true
+	zeroCaPk, err := tu.GenerateKey(tu.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeroCaCert, err := tu.GenerateCertificateFromTemplate(tmpl, zeroCaPk, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeroIprsKey := getIprsPathFromCert(t, zeroCaCert, zeroCaPk, "shared")
+	addCertToDag(t, dag, zeroCaCert)
+	zeroLeadCert, zeroLeadPk, err := tu.GenerateCertificate("team lead cert", zeroCaCert, zeroCaPk, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeroDevCert, zeroDevPk, err := tu.GenerateChildCertificate("developer cert", zeroLeadCert, zeroLeadPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Direct children are fine
+	err = verifyNewRecord(zeroIprsKey, rec.NewCertRecordSigner(zeroLeadCert, zeroLeadPk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Grandchildren are not
+	err = verifyNewRecord(zeroIprsKey, rec.NewCertRecordSigner(zeroDevCert, zeroDevPk, zeroLeadCert))
+	if err != c.CertificatePathLenError {
+		t.Fatalf("Expected CertificatePathLenError, got %v", err)
+	}
+}
+
+func TestCertRecordVerificationIdConstraints(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	certManager := c.NewCertificateManager(dag)
+	verifier := rec.NewCertRecordVerifier(certManager)
+
+	// Creates a record with the signer, adds its nodes
+	// to the DAG and verifies it under the CA's path
+	var verifyNewRecord = func(iprsKey rsp.IprsPath, s *rec.CertRecordSigner) error {
+		val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+		record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addRecordNodesToDag(t, dag, record)
+		return verifier.VerifyRecord(ctx, iprsKey, record)
+	}
+
+	caCert, caPk, err := tu.GenerateCACertificate("alice ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addCertToDag(t, dag, caCert)
+	bobsRepoKey := getIprsPathFromCert(t, caCert, caPk, "bobs-repo")
+	photosKey := getIprsPathFromCert(t, caCert, caPk, "photos")
+
+	// Bob may only update bobs-repo
+	bobCert, bobPk, err := tu.GenerateIdConstrainedCertificate("bob cert", []string{"bobs-repo"}, caCert, caPk, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobSigner := rec.NewCertRecordSigner(bobCert, bobPk)
+	err = verifyNewRecord(bobsRepoKey, bobSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyNewRecord(photosKey, bobSigner)
+	if err != c.IdNotPermittedError {
+		t.Fatalf("Expected IdNotPermittedError, got %v", err)
+	}
+
+	// Alice can still sign records for any ID
+	err = verifyNewRecord(photosKey, rec.NewCertRecordSigner(caCert, caPk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Constraints on an intermediate certificate apply to the
+	// certificates it issues
+	leadCert, leadPk, err := tu.GenerateIdConstrainedCertificate("team lead cert", []string{"bobs-*"}, caCert, caPk, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devCert, devPk, err := tu.GenerateChildCertificate("developer cert", leadCert, leadPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devSigner := rec.NewCertRecordSigner(devCert, devPk, leadCert)
+	err = verifyNewRecord(bobsRepoKey, devSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyNewRecord(photosKey, devSigner)
+	if err != c.IdNotPermittedError {
+		t.Fatalf("Expected IdNotPermittedError, got %v", err)
+	}
+}
+
 /*
 func deleteFromRouting(t *testing.T, r *vs.MockValueStore, cert *x509.Certificate) {
 	certHash, err := c.GetCertificateHash(cert)
@@ -214,7 +606,30 @@ func deleteFromRouting(t *testing.T, r *vs.MockValueStore, cert *x509.Certificat
 	}
 }
 */
-func getIprsPathFromCert(t *testing.T, cert *x509.Certificate, certPk *rsa.PrivateKey, id string) rsp.IprsPath {
+// Put the certificate onto the network
+func addCertToDag(t *testing.T, dag mdag.DAGService, cert *x509.Certificate) {
+	b, err := c.MarshalCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dag.Add(ld.Certificate(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Put the nodes required to verify the record onto the network
+// (without checking the record, as the publisher would)
+func addRecordNodesToDag(t *testing.T, dag mdag.DAGService, record *rec.Record) {
+	for _, n := range record.DependencyNodes() {
+		_, err := dag.Add(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func getIprsPathFromCert(t *testing.T, cert *x509.Certificate, certPk crypto.Signer, id string) rsp.IprsPath {
 	s := rec.NewCertRecordSigner(cert, certPk)
 	bp, err := s.BasePath(id)
 	if err != nil {
@@ -90,6 +90,14 @@ func (v *eolRecordChecker) SelectRecord(recs []*Record) (int, error) {
 			continue
 		}
 
+		// Records with a higher sequence number are newer
+		if r.Sequence != recs[best_i].Sequence {
+			if r.Sequence > recs[best_i].Sequence {
+				best_i = i
+			}
+			continue
+		}
+
 		if rt.After(bestt) {
 			best_i = i
 			continue
@@ -27,7 +27,7 @@ func setupNewEolRecordFunc(t *testing.T) func(time.Time, string) *Record {
 		}
 		vl := NewEolRecordValidation(eol)
 		s := NewKeyRecordSigner(pk)
-		r, err := NewRecord(vl, s, c.Bytes())
+		r, err := NewRecord(vl, s, c.Bytes(), 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -90,3 +90,39 @@ func TestEolValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestEolSequenceOrdering(t *testing.T) {
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewKeyRecordSigner(pk)
+
+	var NewRecordSeq = func(eol time.Time, p string, seq uint64) *Record {
+		c, err := cid.Parse(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewRecord(NewEolRecordValidation(eol), s, c.Bytes(), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	ts := time.Unix(1000000, 0)
+
+	p1 := "/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN"
+	p2 := "/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy"
+
+	r1 := NewRecordSeq(ts.Add(time.Hour), p1, 1)
+	r2 := NewRecordSeq(ts.Add(time.Hour), p2, 2)
+	r3 := NewRecordSeq(ts.Add(time.Hour*2), p1, 0)
+
+	// Same EOL, r2 has the higher sequence number
+	assertEolSelected(t, r2, r1, r2)
+
+	// r3 has a later EOL but r2 has the higher sequence number
+	assertEolSelected(t, r2, r1, r2, r3)
+}
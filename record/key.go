@@ -15,6 +15,9 @@ type KeyRecordSigner struct {
 	pubkNode node.Node
 }
 
+// NewKeyRecordSigner creates a signer that signs records with the given
+// private key. Any key type supported by go-libp2p-crypto can be used,
+// eg RSA, Ed25519 or Secp256k1.
 func NewKeyRecordSigner(pk ci.PrivKey) *KeyRecordSigner {
 	return &KeyRecordSigner{pk, nil}
 }
@@ -81,7 +84,7 @@ func (v *KeyRecordVerifier) VerifyRecord(ctx context.Context, iprsKey rsp.IprsPa
 	}
 
 	// Check signature
-	sigd, err := dataForSig(record.Value, record.Validity)
+	sigd, err := dataForSig(record.Version, record.Value, record.Sequence, record.Validity)
 	if err != nil {
 		return fmt.Errorf("Failed to marshall data for signature for path [%s]: %v", iprsKey, err)
 	}
@@ -38,7 +38,7 @@ func TestKeyRecordVerification(t *testing.T) {
 		}
 		vl := rec.NewEolRecordValidation(eol)
 		s := rec.NewKeyRecordSigner(pk)
-		rec, err := rec.NewRecord(vl, s, c.Bytes())
+		rec, err := rec.NewRecord(vl, s, c.Bytes(), 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -102,3 +102,65 @@ func getIprsPathFromKey(t *testing.T, pk ci.PrivKey, id string) rsp.IprsPath {
 	}
 	return bp
 }
+
+func TestKeyRecordVerificationKeyTypes(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	id := testutil.RandIdentityOrFatal(t)
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := tu.NewMockValueStore(ctx, id, dstore)
+	pubkManager := rec.NewPublicKeyManager(dag)
+	verifier := rec.NewKeyRecordVerifier(pubkManager)
+	publisher := psh.NewDHTPublisher(r, dag)
+
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tk := range tu.TestKeys {
+		name := tk.Name
+		pk, err := tk.PrivKey()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		pubk := pk.GetPublic()
+
+		s := rec.NewKeyRecordSigner(pk)
+		iprsKey, err := s.BasePath("myrec")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		record, err := rec.NewRecord(rec.NewEolRecordValidation(time.Now().Add(time.Hour)), s, c.Bytes(), 0)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		err = publisher.Publish(ctx, iprsKey, record)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		// Public key should be retrievable by its CID
+		fetched, err := pubkManager.GetPublicKey(ctx, iprsKey.Cid())
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !fetched.Equals(pubk) {
+			t.Fatalf("%s: fetched public key does not match", name)
+		}
+
+		err = verifier.VerifyRecord(ctx, iprsKey, record)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		// Tampering with the signature should cause verification to fail
+		tampered := *record
+		tampered.Signature = append([]byte{}, record.Signature...)
+		tampered.Signature[0] ^= 0xff
+		err = verifier.VerifyRecord(ctx, iprsKey, &tampered)
+		if err == nil {
+			t.Fatalf("%s: Failed to return error for tampered signature", name)
+		}
+	}
+}
@@ -111,6 +111,14 @@ func (v *rangeRecordChecker) SelectRecord(recs []*Record) (int, error) {
 			continue
 		}
 
+		// Records with a higher sequence number are newer
+		if r.Sequence != recs[best_i].Sequence {
+			if r.Sequence > recs[best_i].Sequence {
+				best_i = i
+			}
+			continue
+		}
+
 		// Best record is the one that's valid to the latest possible moment
 		if t[1] == nil && bestt[1] != nil || (t[1] != nil && bestt[1] != nil && (*t[1]).After(*bestt[1])) {
 			best_i = i
@@ -30,7 +30,7 @@ func setupNewRangeRecordFunc(t *testing.T) func(*time.Time, *time.Time, string)
 			t.Fatal(err)
 		}
 		s := NewKeyRecordSigner(pk)
-		r, err := NewRecord(vl, s, c.Bytes())
+		r, err := NewRecord(vl, s, c.Bytes(), 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -184,3 +184,45 @@ func TestRangeValidation(t *testing.T) {
 		t.Fatal("Expected expired error")
 	}
 }
+
+func TestRangeSequenceOrdering(t *testing.T) {
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewKeyRecordSigner(pk)
+
+	var NewRecordSeq = func(start *time.Time, end *time.Time, p string, seq uint64) *Record {
+		c, err := cid.Parse(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vl, err := NewRangeRecordValidation(start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewRecord(vl, s, c.Bytes(), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	var EndOfTime *time.Time
+	ts := time.Unix(1000000, 0)
+	InOneHour := ts.Add(time.Hour)
+
+	p1 := "/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN"
+	p2 := "/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy"
+
+	r1 := NewRecordSeq(&ts, &InOneHour, p1, 1)
+	r2 := NewRecordSeq(&ts, &InOneHour, p2, 2)
+	r3 := NewRecordSeq(&ts, EndOfTime, p1, 0)
+
+	// Same time range, r2 has the higher sequence number
+	assertRangeSelected(t, r2, r1, r2)
+
+	// r3 is valid for longer but r2 has the higher sequence number
+	assertRangeSelected(t, r2, r1, r2, r3)
+}
@@ -1,7 +1,9 @@
 package iprs_record
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"time"
 
 	ld "github.com/dirkmc/go-iprs/ipld"
@@ -14,6 +16,10 @@ const PublishPutValTimeout = time.Second * 10
 
 var log = logging.Logger("iprs.record")
 
+// ErrSignerMismatch is returned when a record was not signed with the
+// expected key or certificate
+var ErrSignerMismatch = errors.New("Record was signed with a different key or certificate")
+
 type RecordValidation interface {
 	ValidationType() ld.IprsValidationType
 	// Return the validation data for the record
@@ -54,7 +60,10 @@ type Record struct {
 	nodes []node.Node
 }
 
-func NewRecord(vl RecordValidation, s RecordSigner, val []byte) (*Record, error) {
+// NewRecord creates a record with the given value, signed by the signer.
+// The sequence number should be incremented each time a new record is
+// published to the same IPRS path.
+func NewRecord(vl RecordValidation, s RecordSigner, val []byte, seq uint64) (*Record, error) {
 	vfn, err := s.Verification()
 	if err != nil {
 		return nil, err
@@ -70,7 +79,7 @@ func NewRecord(vl RecordValidation, s RecordSigner, val []byte) (*Record, error)
 		Validation:       vdn,
 	}
 
-	signable, err := dataForSig(val, validity)
+	signable, err := dataForSig(ld.Version, val, seq, validity)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +89,7 @@ func NewRecord(vl RecordValidation, s RecordSigner, val []byte) (*Record, error)
 		return nil, err
 	}
 
-	n, err := ld.NewIprsNode(val, validity, sig)
+	n, err := ld.NewIprsNode(val, seq, validity, sig)
 	if err != nil {
 		return nil, err
 	}
@@ -111,3 +120,42 @@ func NewRecordFromNode(n *ld.Node) *Record {
 func (r *Record) DependencyNodes() []node.Node {
 	return r.nodes
 }
+
+// CheckSigner checks that the record at the IPRS path was signed with the
+// same key or certificate chain as the signer
+func CheckSigner(iprsKey rsp.IprsPath, record *Record, s RecordSigner) error {
+	vt := record.Validity.VerificationType
+	if vt != s.VerificationType() {
+		return ErrSignerMismatch
+	}
+
+	// Compare the verification data, eg the certificate chain
+	vfn, err := s.Verification()
+	if err != nil {
+		return err
+	}
+	sb, err := VerificationSigPreparer.PrepareSig(vt, vfn)
+	if err != nil {
+		return err
+	}
+	rb, err := VerificationSigPreparer.PrepareSig(vt, record.Validity.Verification)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sb, rb) {
+		return ErrSignerMismatch
+	}
+
+	// Records signed with a key are verified with the key in the IPRS path
+	if vt == ld.VerificationType_Key {
+		bp, err := s.BasePath(iprsKey.Id())
+		if err != nil {
+			return err
+		}
+		if bp.BasePath() != iprsKey.BasePath() {
+			return ErrSignerMismatch
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package iprs_record
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	c "github.com/dirkmc/go-iprs/certificate"
+	ld "github.com/dirkmc/go-iprs/ipld"
+	rsp "github.com/dirkmc/go-iprs/path"
+	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+// RevocationListId is the ID under the CA certificate's IPRS path at
+// which the CA owner publishes the list of revoked certificates, ie
+// /iprs/<ca cert cid>/_revocations
+const RevocationListId = "_revocations"
+
+const RevocationListFetchTimeout = time.Second * 10
+
+// The number of candidate revocation records requested from the value store
+const revocationRecordCount = 16
+
+// ErrRevocationListSigner is returned when a revocation list is not signed
+// by the CA certificate itself
+var ErrRevocationListSigner = errors.New("Revocation list is not signed by the CA certificate")
+
+// RevokedCert identifies a revoked certificate. Serial numbers are only
+// unique per issuer, so the certificate is identified by its issuer and its
+// serial number.
+type RevokedCert struct {
+	Issuer       *x509.Certificate
+	SerialNumber *big.Int
+}
+
+// NewRevokedCert identifies the certificate issued by issuer
+func NewRevokedCert(cert, issuer *x509.Certificate) RevokedCert {
+	return RevokedCert{Issuer: issuer, SerialNumber: cert.SerialNumber}
+}
+
+func (rc RevokedCert) key() string {
+	return revokedCertKey(rc.Issuer, rc.SerialNumber)
+}
+
+func revokedCertKey(issuer *x509.Certificate, serial *big.Int) string {
+	return c.GetPublicKeyHash(issuer) + "/" + serial.String()
+}
+
+// NewRevocationRecord creates a record that revokes the given certificates.
+// It must be signed by the CA certificate itself and published to the path
+// returned by s.BasePath(RevocationListId).
+// Revoked certificates anywhere in a record's certificate chain can no
+// longer sign records under the CA's path. Once the revocation list
+// expires the certificates are no longer revoked, so it should be renewed
+// before its EOL.
+func NewRevocationRecord(vl RecordValidation, s *CertRecordSigner, certs []RevokedCert, seq uint64) (*Record, error) {
+	keys := make([]string, len(certs))
+	for i, rc := range certs {
+		keys[i] = rc.key()
+	}
+	list, err := ld.NewRevocationListNode(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewRecord(vl, s, list.Cid().Bytes(), seq)
+	if err != nil {
+		return nil, err
+	}
+	r.nodes = append(r.nodes, list)
+
+	return r, nil
+}
+
+// RevocationManager retrieves the revocation lists published by CA
+// certificate owners
+type RevocationManager struct {
+	vstore   routing.ValueStore
+	dag      node.NodeGetter
+	verifier *CertRecordVerifier
+	checker  RecordChecker
+}
+
+func NewRevocationManager(vs routing.ValueStore, dag node.NodeGetter) *RevocationManager {
+	return &RevocationManager{
+		vstore: vs,
+		dag:    dag,
+		// Note: the verifier for the revocation record itself does not
+		// check revocation
+		verifier: NewCertRecordVerifier(c.NewCertificateManager(dag)),
+		checker:  MasterRecordChecker,
+	}
+}
+
+// CheckRevoked returns CertificateRevokedError if any of the certificates
+// in the chain has been revoked by the CA certificate with the given CID.
+// The chain starts with the signing certificate and ends with the CA
+// certificate, with each certificate followed by its issuer.
+func (m *RevocationManager) CheckRevoked(ctx context.Context, caCid *cid.Cid, chain []*x509.Certificate) error {
+	revoked, err := m.GetRevokedCerts(ctx, caCid)
+	if err != nil {
+		return err
+	}
+	for i, cert := range chain[:len(chain)-1] {
+		if revoked[revokedCertKey(chain[i+1], cert.SerialNumber)] {
+			log.Warningf("Certificate with serial number %s has been revoked by CA cert [%s]", cert.SerialNumber, caCid)
+			return c.CertificateRevokedError
+		}
+	}
+	return nil
+}
+
+// GetRevokedCerts gets the set of certificates revoked by the CA
+// certificate with the given CID, keyed by issuer key hash and serial
+// number (see ld.RevocationList). If there is no valid revocation list
+// signed by the CA certificate the set is empty.
+func (m *RevocationManager) GetRevokedCerts(ctx context.Context, caCid *cid.Cid) (map[string]bool, error) {
+	revoked := make(map[string]bool)
+
+	iprsKey, err := rsp.FromString("/iprs/" + caCid.String() + "/" + RevocationListId)
+	if err != nil {
+		return nil, err
+	}
+
+	timectx, cancel := context.WithTimeout(ctx, RevocationListFetchTimeout)
+	defer cancel()
+
+	vals, err := m.vstore.GetValues(timectx, iprsKey.String(), revocationRecordCount)
+	if err == routing.ErrNotFound || err == ds.ErrNotFound {
+		return revoked, nil
+	}
+	if err != nil {
+		log.Warningf("Failed to retrieve revocation list %s from value store: %s", iprsKey, err)
+		return nil, err
+	}
+
+	// Ignore any revocation records that are not signed by the CA
+	// certificate itself, or that are not valid
+	var recs []*Record
+	for _, v := range vals {
+		r, err := m.getRevocationRecord(timectx, iprsKey, v.Val)
+		if err != nil {
+			log.Warningf("Ignoring revocation record for %s: %s", iprsKey, err)
+			continue
+		}
+		recs = append(recs, r)
+	}
+	if len(recs) == 0 {
+		return revoked, nil
+	}
+
+	i, err := m.checker.SelectRecord(recs)
+	if err != nil {
+		return nil, err
+	}
+
+	listCid, err := cid.Cast(recs[i].Value)
+	if err != nil {
+		return nil, err
+	}
+	n, err := m.dag.Get(timectx, listCid)
+	if err != nil {
+		log.Warningf("Failed to fetch revocation list %s for %s: %s", listCid, iprsKey, err)
+		return nil, err
+	}
+	list, err := ld.DecodeRevocationListBlock(n)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range list.Certs {
+		revoked[k] = true
+	}
+	return revoked, nil
+}
+
+func (m *RevocationManager) getRevocationRecord(ctx context.Context, iprsKey rsp.IprsPath, b []byte) (*Record, error) {
+	recCid, err := cid.Cast(b)
+	if err != nil {
+		return nil, err
+	}
+	n, err := m.dag.Get(ctx, recCid)
+	if err != nil {
+		return nil, err
+	}
+	iprsNode, err := ld.DecodeIprsBlock(n)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecordFromNode(iprsNode)
+
+	// Only the CA certificate can revoke certificates
+	if r.Validity.VerificationType != ld.VerificationType_Cert {
+		return nil, fmt.Errorf("Revocation record is not signed with a certificate")
+	}
+	chain, err := toCidChain(r.Validity.Verification)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) != 1 || !chain[0].Equals(iprsKey.Cid()) {
+		return nil, ErrRevocationListSigner
+	}
+
+	if err = m.verifier.VerifyRecord(ctx, iprsKey, r); err != nil {
+		return nil, err
+	}
+	if err = m.checker.ValidateRecord(ctx, iprsKey, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
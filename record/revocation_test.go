@@ -0,0 +1,255 @@
+package iprs_record_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	c "github.com/dirkmc/go-iprs/certificate"
+	rsp "github.com/dirkmc/go-iprs/path"
+	psh "github.com/dirkmc/go-iprs/publisher"
+	rec "github.com/dirkmc/go-iprs/record"
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestCertRevocation(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(context.Background(), id, dstore)
+	certManager := c.NewCertificateManager(dag)
+	revocationManager := rec.NewRevocationManager(r, dag)
+	verifier := rec.NewRevocationCheckingCertRecordVerifier(certManager, revocationManager)
+	publisher := psh.NewDHTPublisher(r, dag)
+
+	val, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Creates a record with the signer and publishes it
+	var publishNewRecord = func(iprsKey rsp.IprsPath, s *rec.CertRecordSigner) *rec.Record {
+		vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+		record, err := rec.NewRecord(vl, s, val.Bytes(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = publisher.Publish(ctx, iprsKey, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	// Creates a revocation record with the signer and publishes it
+	var publishRevocations = func(s *rec.CertRecordSigner, eol time.Time, certs ...rec.RevokedCert) {
+		vl := rec.NewEolRecordValidation(eol)
+		record, err := rec.NewRevocationRecord(vl, s, certs, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iprsKey, err := s.BasePath(rec.RevocationListId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = publisher.Publish(ctx, iprsKey, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	caCert, caPk, err := tu.GenerateCACertificate("ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner := rec.NewCertRecordSigner(caCert, caPk)
+	iprsKey, err := caSigner.BasePath("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bobCert, bobPk, err := tu.GenerateChildCertificate("bob cert", caCert, caPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	carolCert, carolPk, err := tu.GenerateChildCertificate("carol cert", caCert, caPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leadCert, leadPk, err := tu.GenerateCertificate("team lead cert", caCert, caPk, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devCert, devPk, err := tu.GenerateChildCertificate("dev cert", leadCert, leadPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ops lead issues a cert with the same serial number as Bob's
+	opsLeadCert, opsLeadPk, err := tu.GenerateCertificate("ops lead cert", caCert, caPk, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsTemplate, err := tu.NewCertificate("ops cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsTemplate.SerialNumber = bobCert.SerialNumber
+	opsPk, err := tu.GenerateKey(tu.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsCert, err := tu.GenerateCertificateFromTemplate(opsTemplate, opsPk, opsLeadCert, opsLeadPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caRec := publishNewRecord(iprsKey, caSigner)
+	bobRec := publishNewRecord(iprsKey, rec.NewCertRecordSigner(bobCert, bobPk))
+	carolRec := publishNewRecord(iprsKey, rec.NewCertRecordSigner(carolCert, carolPk))
+	devRec := publishNewRecord(iprsKey, rec.NewCertRecordSigner(devCert, devPk, leadCert))
+	opsRec := publishNewRecord(iprsKey, rec.NewCertRecordSigner(opsCert, opsPk, opsLeadCert))
+
+	// No revocation list has been published so all records are valid
+	for _, record := range []*rec.Record{caRec, bobRec, carolRec, devRec, opsRec} {
+		err = verifier.VerifyRecord(ctx, iprsKey, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bob leaves the team, and the team lead's cert is revoked
+	publishRevocations(caSigner, time.Now().Add(time.Hour), rec.NewRevokedCert(bobCert, caCert), rec.NewRevokedCert(leadCert, caCert))
+
+	err = verifier.VerifyRecord(ctx, iprsKey, bobRec)
+	if err != c.CertificateRevokedError {
+		t.Fatalf("Expected CertificateRevokedError, got %v", err)
+	}
+
+	// Revoking an intermediate cert revokes the certs it issued
+	err = verifier.VerifyRecord(ctx, iprsKey, devRec)
+	if err != c.CertificateRevokedError {
+		t.Fatalf("Expected CertificateRevokedError, got %v", err)
+	}
+
+	// The ops cert has the same serial number as Bob's cert but a
+	// different issuer, so it is not revoked
+	for _, record := range []*rec.Record{caRec, carolRec, opsRec} {
+		err = verifier.VerifyRecord(ctx, iprsKey, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A verifier that doesn't check revocation still accepts Bob's record
+	err = rec.NewCertRecordVerifier(certManager).VerifyRecord(ctx, iprsKey, bobRec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// So does the value store validator, unless it checks revocation
+	err = rec.NewIprsValidator(dag).Func(iprsKey.String(), bobRec.Cid().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rec.NewRevocationCheckingIprsValidator(r, dag).Func(iprsKey.String(), bobRec.Cid().Bytes())
+	if err != c.CertificateRevokedError {
+		t.Fatalf("Expected CertificateRevokedError, got %v", err)
+	}
+	selector := rec.NewRevocationCheckingIprsSelector(r, dag)
+	i, err := selector(iprsKey.String(), [][]byte{bobRec.Cid().Bytes(), carolRec.Cid().Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1 {
+		t.Fatalf("Selected record %d, expected %d", i, 1)
+	}
+	_, err = selector(iprsKey.String(), [][]byte{bobRec.Cid().Bytes()})
+	if err != rec.NoUsableRecordsError {
+		t.Fatalf("Expected NoUsableRecordsError, got %v", err)
+	}
+
+	// Mallory has a child cert with no ID constraints, so she can sign
+	// records for any ID under the CA's path. She tries to replace the
+	// CA's revocation list with one that revokes nobody.
+	malloryCert, malloryPk, err := tu.GenerateChildCertificate("mallory cert", caCert, caPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallorySigner := rec.NewCertRecordSigner(malloryCert, malloryPk)
+	vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+	forged, err := rec.NewRevocationRecord(vl, mallorySigner, []rec.RevokedCert{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocationKey, err := caSigner.BasePath(rec.RevocationListId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the CA cert can sign the revocation list
+	err = verifier.VerifyRecord(ctx, revocationKey, forged)
+	if err != rec.ErrRevocationListSigner {
+		t.Fatalf("Expected ErrRevocationListSigner, got %v", err)
+	}
+	err = publisher.Publish(ctx, revocationKey, forged)
+	if err != rec.ErrRevocationListSigner {
+		t.Fatalf("Expected ErrRevocationListSigner, got %v", err)
+	}
+
+	otherCaCert, otherCaPk, err := tu.GenerateCACertificate("other ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCaSigner := rec.NewCertRecordSigner(otherCaCert, otherCaPk)
+	otherIprsKey, err := otherCaSigner.BasePath("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daveCert, davePk, err := tu.GenerateChildCertificate("dave cert", otherCaCert, otherCaPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daveRec := publishNewRecord(otherIprsKey, rec.NewCertRecordSigner(daveCert, davePk))
+
+	// Mallory bypasses the publisher and puts her list at the revocation
+	// list path of a CA that hasn't published one. It is not a valid list
+	// so it is ignored, rather than blocking the CA's child certs.
+	otherRevocationKey, err := otherCaSigner.BasePath(rec.RevocationListId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addRecordNodesToDag(t, dag, forged)
+	_, err = dag.Add(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.PutValue(ctx, otherRevocationKey.String(), forged.Cid().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifier.VerifyRecord(ctx, otherIprsKey, daveRec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the revocation list expires, the certs are no longer revoked
+	eol := time.Millisecond * 100
+	publishRevocations(otherCaSigner, time.Now().Add(eol), rec.NewRevokedCert(daveCert, otherCaCert))
+	err = verifier.VerifyRecord(ctx, otherIprsKey, daveRec)
+	if err != c.CertificateRevokedError {
+		t.Fatalf("Expected CertificateRevokedError, got %v", err)
+	}
+
+	time.Sleep(eol)
+	err = verifier.VerifyRecord(ctx, otherIprsKey, daveRec)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,7 @@ package iprs_record
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 
 	ld "github.com/dirkmc/go-iprs/ipld"
@@ -32,7 +33,12 @@ func (s VdnSigPreparer) PrepareSig(t ld.IprsValidationType, v interface{}) ([]by
 
 var ValidationSigPreparer = VdnSigPreparer(map[ld.IprsValidationType]PrepareSig{})
 
-func dataForSig(val []byte, v *ld.Validity) ([]byte, error) {
+// Prefixes the signature data of records with sequence numbers. Values
+// are CIDs or paths, so they never start with a zero byte, which means
+// the signature data of an older record can't start with the prefix.
+var sigDomainSeparator = []byte("\x00iprs-record")
+
+func dataForSig(version uint64, val []byte, seq uint64, v *ld.Validity) ([]byte, error) {
 	vfnb, err := VerificationSigPreparer.PrepareSig(v.VerificationType, v.Verification)
 	if err != nil {
 		return nil, err
@@ -42,11 +48,26 @@ func dataForSig(val []byte, v *ld.Validity) ([]byte, error) {
 		return nil, err
 	}
 
-	return bytes.Join([][]byte{
+	fields := [][]byte{
 		val,
 		[]byte(fmt.Sprint(v.VerificationType)),
 		vfnb,
 		[]byte(fmt.Sprint(v.ValidationType)),
 		vdnb,
-	}, []byte{}), nil
+	}
+
+	// Records from before sequence numbers were added are signed without
+	// them. Newer records are signed with the domain separator, the
+	// version, the sequence number and the length of the value, so that
+	// the signature of an old record can't be reused with a forged
+	// version and sequence number taken from the start of its value.
+	if version >= ld.SequenceVersion {
+		hdr := make([]byte, 24)
+		binary.BigEndian.PutUint64(hdr, version)
+		binary.BigEndian.PutUint64(hdr[8:], seq)
+		binary.BigEndian.PutUint64(hdr[16:], uint64(len(val)))
+		fields = append([][]byte{sigDomainSeparator, hdr}, fields...)
+	}
+
+	return bytes.Join(fields, []byte{}), nil
 }
@@ -0,0 +1,74 @@
+package iprs_record
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	ld "github.com/dirkmc/go-iprs/ipld"
+	u "gx/ipfs/QmPsAfmDBnZN3kZGSuNwvCNDZiHneERSKmRcFyG3UkvcT3/go-ipfs-util"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestLegacySignatureReplay(t *testing.T) {
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewKeyRecordSigner(pk)
+	vfn, err := s.Verification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdn, err := NewEolRecordValidation(time.Now().Add(time.Hour)).Validation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validity := &ld.Validity{
+		VerificationType: s.VerificationType(),
+		Verification:     vfn,
+		ValidationType:   ld.ValidationType_EOL,
+		Validation:       vdn,
+	}
+
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An old record (without a sequence number) whose value starts with
+	// a version and a huge sequence number
+	forgedSeq := uint64(math.MaxUint64)
+	legacyVal := make([]byte, 16)
+	binary.BigEndian.PutUint64(legacyVal, ld.Version)
+	binary.BigEndian.PutUint64(legacyVal[8:], forgedSeq)
+	legacyVal = append(legacyVal, c.Bytes()...)
+	legacyData, err := dataForSig(1, legacyVal, 0, validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := s.SignRecord(legacyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature must not verify for a record with the version and
+	// sequence number taken from the start of the old record's value
+	forged, err := dataForSig(ld.Version, c.Bytes(), forgedSeq, validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := pk.GetPublic().Verify(forged, sig)
+	if err == nil && ok {
+		t.Fatal("Expected signature of old record not to verify for forged record")
+	}
+
+	// The old record's signature still verifies
+	ok, err = pk.GetPublic().Verify(legacyData, sig)
+	if err != nil || !ok {
+		t.Fatal("Expected signature of old record to verify")
+	}
+}
@@ -32,49 +32,71 @@ func (m *masterRecordChecker) ValidateRecord(ctx context.Context, iprsKey rsp.Ip
 	return checker.ValidateRecord(ctx, iprsKey, record)
 }
 
+// SelectRecord selects the record with the highest sequence number. If
+// records with the highest sequence number have different validation
+// types, only those with the lowest validation type are compared, so that
+// the selected record doesn't depend on the order of the records.
 func (m *masterRecordChecker) SelectRecord(recs []*Record) (int, error) {
-	var firstRec *Record
-	var checkRecord = func(rec *Record, i int) bool {
-		_, ok := m.Checkers[rec.Validity.ValidationType]
-		if !ok {
+	// Find the highest sequence number of the records that can be checked
+	var usable []*Record
+	var seq uint64
+	for i, rec := range recs {
+		if _, ok := m.Checkers[rec.Validity.ValidationType]; !ok {
 			t := rec.Validity.ValidationType
 			log.Warningf("No record checker found for record with validity type %d at index %d of %d", t, i, len(recs))
-			return false
+			continue
 		}
-
-		if firstRec == nil {
-			firstRec = rec
-			return true
+		if len(usable) == 0 || rec.Sequence > seq {
+			seq = rec.Sequence
 		}
-
-		if rec.Validity.ValidationType != firstRec.Validity.ValidationType {
-			t1 := firstRec.Validity.ValidationType
-			t2 := rec.Validity.ValidationType
-			log.Warningf("Records have mixed validity types (%d and %d). Ignoring %d at index %d of %d", t1, t2, t2, i, len(recs))
-			return false
-		}
-
-		return true
+		usable = append(usable, rec)
 	}
 
-	var usable []*Record
-	for i, rec := range recs {
-		if checkRecord(rec, i) {
-			usable = append(usable, rec)
-		}
+	if len(usable) == 0 {
+		return 0, NoUsableRecordsError
 	}
 
-	if len(recs) == 0 {
-		return 0, NoUsableRecordsError
+	// Compare the records with the highest sequence number that have the
+	// lowest validation type
+	var newest []*Record
+	for _, rec := range usable {
+		if rec.Sequence != seq {
+			continue
+		}
+		if len(newest) > 0 {
+			t1 := newest[0].Validity.ValidationType
+			t2 := rec.Validity.ValidationType
+			if t1 != t2 {
+				log.Warningf("Records with sequence number %d have mixed validity types (%d and %d)", seq, t1, t2)
+			}
+			if t2 > t1 {
+				continue
+			}
+			if t2 < t1 {
+				newest = nil
+			}
+		}
+		newest = append(newest, rec)
 	}
 
-	checker, ok := m.Checkers[recs[0].Validity.ValidationType]
+	checker, ok := m.Checkers[newest[0].Validity.ValidationType]
 	if !ok {
 		// In theory this is not actually possible
 		return 0, NoUsableRecordsError
 	}
 
-	return checker.SelectRecord(usable)
+	// Map the index in the newest set back to the index in the
+	// original set
+	i, err := checker.SelectRecord(newest)
+	if err != nil {
+		return 0, err
+	}
+	for j, rec := range recs {
+		if rec == newest[i] {
+			return j, nil
+		}
+	}
+	return 0, NoUsableRecordsError
 }
 
 var MasterRecordChecker = NewMasterRecordChecker()
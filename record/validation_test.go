@@ -0,0 +1,66 @@
+package iprs_record
+
+import (
+	"testing"
+	"time"
+
+	u "gx/ipfs/QmPsAfmDBnZN3kZGSuNwvCNDZiHneERSKmRcFyG3UkvcT3/go-ipfs-util"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestSelectRecordMixedValidationTypes(t *testing.T) {
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewKeyRecordSigner(pk)
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newRecord = func(vl RecordValidation, seq uint64) *Record {
+		r, err := NewRecord(vl, s, c.Bytes(), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	eol := NewEolRecordValidation(time.Now().Add(time.Hour))
+	end := time.Now().Add(time.Hour)
+	rng, err := NewRangeRecordValidation(nil, &end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assertSelected = func(expected *Record, recs ...*Record) {
+		i, err := MasterRecordChecker.SelectRecord(recs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recs[i] != expected {
+			t.Fatalf("Selected record %d with sequence number %d, expected sequence number %d", i, recs[i].Sequence, expected.Sequence)
+		}
+	}
+
+	// A newer TimeRange record is selected over an older EOL record, and
+	// a newer EOL record over an older TimeRange record, in either order
+	eolOld := newRecord(eol, 1)
+	rangeNew := newRecord(rng, 2)
+	assertSelected(rangeNew, eolOld, rangeNew)
+	assertSelected(rangeNew, rangeNew, eolOld)
+
+	rangeOld := newRecord(rng, 1)
+	eolNew := newRecord(eol, 2)
+	assertSelected(eolNew, rangeOld, eolNew)
+	assertSelected(eolNew, eolNew, rangeOld)
+
+	// If the newest records have the same sequence number, the record
+	// with the lowest validation type is selected in either order
+	assertSelected(eolNew, rangeNew, eolNew)
+	assertSelected(eolNew, eolNew, rangeNew)
+	assertSelected(eolNew, eolOld, rangeNew, eolNew)
+	assertSelected(eolNew, eolNew, rangeNew, eolOld)
+}
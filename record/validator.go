@@ -0,0 +1,149 @@
+package iprs_record
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ld "github.com/dirkmc/go-iprs/ipld"
+	rsp "github.com/dirkmc/go-iprs/path"
+	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	record "gx/ipfs/QmWGtsyPYEoiqTtWLpeUA2jpW4YSZgarKDD2zivYAFz7sR/go-libp2p-record"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+// IprsType is the namespace under which IPRS record references are
+// stored in the value store, eg /iprs/<cid>/id
+const IprsType = "iprs"
+
+// Timeout for fetching and verifying a record during validation
+const ValidateRecordTimeout = time.Second * 30
+
+type iprsValueValidator struct {
+	dag      node.NodeGetter
+	verifier *MasterRecordVerifier
+	checker  RecordChecker
+}
+
+func newIprsValueValidator(dag node.NodeGetter, verifier *MasterRecordVerifier) *iprsValueValidator {
+	return &iprsValueValidator{
+		dag:      dag,
+		verifier: verifier,
+		checker:  MasterRecordChecker,
+	}
+}
+
+// NewIprsValidator returns a ValidChecker for the iprs namespace that
+// verifies that the value stored at an IPRS path is the CID of a
+// correctly signed IPRS record that has not expired. Records that are not
+// yet valid are accepted, as the publisher allows them to be published
+// ahead of time.
+// Certificates are not checked against the CA's revocation list, so
+// records signed by a revoked certificate are accepted (the resolver still
+// rejects them). Use NewRevocationCheckingIprsValidator to reject them.
+func NewIprsValidator(dag node.NodeGetter) *record.ValidChecker {
+	return newIprsValidChecker(newIprsValueValidator(dag, NewMasterRecordVerifier(dag)))
+}
+
+// NewRevocationCheckingIprsValidator returns a ValidChecker like
+// NewIprsValidator that also rejects records signed by a certificate that
+// has been revoked by a revocation list in the value store
+func NewRevocationCheckingIprsValidator(vs routing.ValueStore, dag node.NodeGetter) *record.ValidChecker {
+	return newIprsValidChecker(newIprsValueValidator(dag, NewRevocationCheckingMasterRecordVerifier(vs, dag)))
+}
+
+func newIprsValidChecker(v *iprsValueValidator) *record.ValidChecker {
+	return &record.ValidChecker{
+		Func: v.Validate,
+		Sign: false,
+	}
+}
+
+// NewIprsSelector returns a SelectorFunc for the iprs namespace that
+// selects the best of several competing IPRS record CIDs. Like
+// NewIprsValidator it does not check revocation.
+func NewIprsSelector(dag node.NodeGetter) record.SelectorFunc {
+	return newIprsValueValidator(dag, NewMasterRecordVerifier(dag)).Select
+}
+
+// NewRevocationCheckingIprsSelector returns a SelectorFunc like
+// NewIprsSelector that ignores records signed by a revoked certificate
+func NewRevocationCheckingIprsSelector(vs routing.ValueStore, dag node.NodeGetter) record.SelectorFunc {
+	return newIprsValueValidator(dag, NewRevocationCheckingMasterRecordVerifier(vs, dag)).Select
+}
+
+// Validate implements ValidatorFunc
+func (v *iprsValueValidator) Validate(k string, val []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ValidateRecordTimeout)
+	defer cancel()
+
+	_, err := v.getRecord(ctx, k, val)
+	return err
+}
+
+// Select implements SelectorFunc
+func (v *iprsValueValidator) Select(k string, vals [][]byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ValidateRecordTimeout)
+	defer cancel()
+
+	var recs []*Record
+	var indices []int
+	for i, val := range vals {
+		r, err := v.getRecord(ctx, k, val)
+		if err != nil {
+			log.Warningf("Ignoring invalid IPRS record at index %d of %d for %s: %s", i, len(vals), k, err)
+			continue
+		}
+		recs = append(recs, r)
+		indices = append(indices, i)
+	}
+
+	if len(recs) == 0 {
+		return 0, NoUsableRecordsError
+	}
+
+	i, err := v.checker.SelectRecord(recs)
+	if err != nil {
+		return 0, err
+	}
+	return indices[i], nil
+}
+
+func (v *iprsValueValidator) getRecord(ctx context.Context, k string, val []byte) (*Record, error) {
+	iprsKey, err := rsp.FromString(k)
+	if err != nil {
+		return nil, err
+	}
+	if k != iprsKey.BasePath() {
+		return nil, fmt.Errorf("IPRS record key [%s] is not a base path", k)
+	}
+
+	c, err := cid.Cast(val)
+	if err != nil {
+		return nil, fmt.Errorf("IPRS record value at [%s] is not a CID: %s", k, err)
+	}
+	if c.Type() != ld.CodecIprsCbor {
+		return nil, fmt.Errorf("Cid Codec %d is not CodecIprsCbor in Cid %s", c.Type(), c)
+	}
+
+	n, err := v.dag.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch IPRS record %s for [%s]: %s", c, k, err)
+	}
+	iprsNode, err := ld.DecodeIprsBlock(n)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecordFromNode(iprsNode)
+
+	if err = v.verifier.Verify(ctx, iprsKey, r); err != nil {
+		return nil, err
+	}
+	err = v.checker.ValidateRecord(ctx, iprsKey, r)
+	if err != nil && err != ErrPendingRecord {
+		return nil, err
+	}
+
+	return r, nil
+}
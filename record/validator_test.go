@@ -0,0 +1,146 @@
+package iprs_record_test
+
+import (
+	"testing"
+	"time"
+
+	rec "github.com/dirkmc/go-iprs/record"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	u "gx/ipfs/QmPsAfmDBnZN3kZGSuNwvCNDZiHneERSKmRcFyG3UkvcT3/go-ipfs-util"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestIprsValidatorAndSelector(t *testing.T) {
+	dag := dstest.Mock()
+	validator := rec.NewIprsValidator(dag)
+	selector := rec.NewIprsSelector(dag)
+
+	sr := u.NewSeededRand(15)
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherpk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Helper function to create a record and add it to the DAG
+	// (without the checks the publisher would make)
+	var addNewRecord = func(pk ci.PrivKey, eol time.Time) *rec.Record {
+		vl := rec.NewEolRecordValidation(eol)
+		s := rec.NewKeyRecordSigner(pk)
+		rec, err := rec.NewRecord(vl, s, c.Bytes(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addRecordNodesToDag(t, dag, rec)
+		_, err = dag.Add(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	ts := time.Now()
+	iprsKey := getIprsPathFromKey(t, pk, "myrec")
+	k := iprsKey.String()
+	r1 := addNewRecord(pk, ts.Add(time.Hour))
+	r2 := addNewRecord(pk, ts.Add(time.Hour*2))
+	expired := addNewRecord(pk, ts.Add(time.Hour*-1))
+	forged := addNewRecord(otherpk, ts.Add(time.Hour*3))
+
+	// Valid record
+	err = validator.Func(k, r1.Cid().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bad key
+	err = validator.Func("/iprs/notacid/myrec", r1.Cid().Bytes())
+	if err == nil {
+		t.Fatal("Failed to return error for invalid IPRS path")
+	}
+	err = validator.Func(k+"/sub/path", r1.Cid().Bytes())
+	if err == nil {
+		t.Fatal("Failed to return error for IPRS path that is not a base path")
+	}
+
+	// Bad value
+	err = validator.Func(k, []byte("not a cid"))
+	if err == nil {
+		t.Fatal("Failed to return error for value that is not a CID")
+	}
+	err = validator.Func(k, c.Bytes())
+	if err == nil {
+		t.Fatal("Failed to return error for CID that is not an IPRS record")
+	}
+
+	// Expired record
+	err = validator.Func(k, expired.Cid().Bytes())
+	if err == nil {
+		t.Fatal("Failed to return error for expired record")
+	}
+
+	// Record that is not yet valid, which the publisher allows to be
+	// published ahead of time
+	start := ts.Add(time.Hour)
+	rvl, err := rec.NewRangeRecordValidation(&start, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := rec.NewRecord(rvl, rec.NewKeyRecordSigner(pk), c.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addRecordNodesToDag(t, dag, pending)
+	_, err = dag.Add(pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validator.Func(k, pending.Cid().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := selector(k, [][]byte{expired.Cid().Bytes(), pending.Cid().Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1 {
+		t.Fatalf("Selected record %d, expected %d", i, 1)
+	}
+
+	// Record signed with a key that doesn't match the path
+	err = validator.Func(k, forged.Cid().Bytes())
+	if err == nil {
+		t.Fatal("Failed to return error for record signed with a different key")
+	}
+
+	// Selector should ignore invalid records and choose the record
+	// with the latest EOL
+	vals := [][]byte{
+		r1.Cid().Bytes(),
+		forged.Cid().Bytes(),
+		r2.Cid().Bytes(),
+		[]byte("not a cid"),
+		expired.Cid().Bytes(),
+	}
+	i, err = selector(k, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 2 {
+		t.Fatalf("Selected record %d, expected %d", i, 2)
+	}
+
+	_, err = selector(k, [][]byte{forged.Cid().Bytes(), expired.Cid().Bytes()})
+	if err == nil {
+		t.Fatal("Failed to return error when no records are valid")
+	}
+}
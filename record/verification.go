@@ -8,6 +8,7 @@ import (
 	ld "github.com/dirkmc/go-iprs/ipld"
 	rsp "github.com/dirkmc/go-iprs/path"
 	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
 )
 
 type MasterRecordVerifier struct {
@@ -25,6 +26,17 @@ func NewMasterRecordVerifier(dag node.NodeGetter) *MasterRecordVerifier {
 	return &MasterRecordVerifier{verifiers}
 }
 
+// NewRevocationCheckingMasterRecordVerifier creates a verifier that also
+// checks the revocation lists published to the value store by CA
+// certificate owners
+func NewRevocationCheckingMasterRecordVerifier(vs routing.ValueStore, dag node.NodeGetter) *MasterRecordVerifier {
+	m := NewMasterRecordVerifier(dag)
+	certm := c.NewCertificateManager(dag)
+	rm := NewRevocationManager(vs, dag)
+	m.Verifiers[ld.VerificationType_Cert] = NewRevocationCheckingCertRecordVerifier(certm, rm)
+	return m
+}
+
 // Verifies that the given record is correctly signed etc
 func (m *MasterRecordVerifier) Verify(ctx context.Context, iprsKey rsp.IprsPath, record *Record) error {
 	verifier, ok := m.Verifiers[record.Validity.VerificationType]
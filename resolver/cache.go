@@ -2,38 +2,100 @@ package iprs_resolver
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	lru "gx/ipfs/QmVYxfoJQiZijTgPNHCHgHELvQpbsJNTg6Crmc3dQkj3yy/golang-lru"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
 )
 
 const DefaultResolverCacheTTL = time.Minute
 
+// CacheRefreshTimeout is the maximum time spent refreshing a stale cache
+// entry in the background
+const CacheRefreshTimeout = time.Second * 30
+
 type CacheOpts struct {
 	size int
 	ttl  *time.Duration
+	// How long failed lookups are cached for. Nil or zero disables
+	// negative caching.
+	negativeTTL *time.Duration
+	// If staleWhileRevalidate is true, entries whose TTL has passed are
+	// still served until the EOL of their record, while they are
+	// refreshed in the background
+	staleWhileRevalidate bool
+	// If datastore is not nil, values are persisted to it with a
+	// DatastoreCache layered under the in-memory cache
+	datastore     ds.Datastore
+	persistentTTL time.Duration
 }
 
 type ValueGetter interface {
 	GetValue(ctx context.Context, k string) (val []byte, eol *time.Time, e error)
 }
 
+// negativeError is returned by a ValueGetter when a lookup failed in a way
+// that is not likely to be transient, ie the name was not found or its
+// record failed verification, so that the failure can be cached. The
+// ResolverCache returns the underlying error to the caller.
+type negativeError struct {
+	err error
+}
+
+func (e *negativeError) Error() string {
+	return e.err.Error()
+}
+
+// Marks the error as one that can be negatively cached
+func negativeErr(err error) error {
+	return &negativeError{err}
+}
+
 type ResolverCache struct {
-	vg    ValueGetter
-	cache *lru.Cache
-	ttl   time.Duration
+	vg                   ValueGetter
+	cache                *lru.Cache
+	ttl                  time.Duration
+	negativeTTL          time.Duration
+	staleWhileRevalidate bool
+
+	// The lookups that are in progress, by key
+	lk       sync.Mutex
+	inflight map[string]*fetchCall
+}
+
+// A lookup that is in progress. Concurrent lookups for the same key wait
+// for it to complete and share its result.
+type fetchCall struct {
+	done   chan struct{}
+	val    []byte
+	record *RecordInfo
+	err    error
+	// True if the lookup failed because its context was cancelled or
+	// timed out
+	cancelled bool
+	// True if the key was invalidated while the lookup was in progress,
+	// in which case the result is not cached
+	invalidated bool
 }
 
 type cacheEntry struct {
 	val []byte
+	// The time until which the entry is fresh
 	eol time.Time
+	// The EOL of the record the value came from, if any. A stale entry
+	// can be served until then.
+	recordEol *time.Time
+	record    *RecordInfo
+	// The error, if the lookup failed
+	err error
 }
 
 // cachesize is the limit of the number of entries in the lru cache. Setting it
 // to '0' will disable caching.
 func NewResolverCache(vg ValueGetter, opts *CacheOpts) *ResolverCache {
 	if opts == nil {
-		opts = &CacheOpts{10, nil}
+		opts = &CacheOpts{size: 10}
 	}
 	var cache *lru.Cache
 	if opts.size > 0 {
@@ -43,18 +105,35 @@ func NewResolverCache(vg ValueGetter, opts *CacheOpts) *ResolverCache {
 		ttl := DefaultResolverCacheTTL
 		opts.ttl = &ttl
 	}
-	return &ResolverCache{vg, cache, *opts.ttl}
+	var negativeTTL time.Duration
+	if opts.negativeTTL != nil {
+		negativeTTL = *opts.negativeTTL
+	}
+	if opts.datastore != nil {
+		vg = NewDatastoreCache(vg, opts.datastore, opts.persistentTTL)
+	}
+	return &ResolverCache{
+		vg:                   vg,
+		cache:                cache,
+		ttl:                  *opts.ttl,
+		negativeTTL:          negativeTTL,
+		staleWhileRevalidate: opts.staleWhileRevalidate,
+		inflight:             make(map[string]*fetchCall),
+	}
 }
 
-func (r *ResolverCache) cacheGet(k string) ([]byte, bool) {
+// Gets the entry for the key from the cache. If the entry is stale (its
+// TTL has passed but it can be served while it is refreshed), the second
+// return value is true.
+func (r *ResolverCache) cacheGet(k string) (*cacheEntry, bool, bool) {
 	if r.cache == nil {
-		return nil, false
+		return nil, false, false
 	}
 
 	// Get the value from the cache
 	ientry, ok := r.cache.Get(k)
 	if !ok {
-		return nil, false
+		return nil, false, false
 	}
 
 	// Make sure it's the right type
@@ -65,17 +144,24 @@ func (r *ResolverCache) cacheGet(k string) ([]byte, bool) {
 	}
 
 	// If it's not expired, return it
-	if time.Now().Before(centry.eol) {
-		return centry.val, true
+	now := time.Now()
+	if now.Before(centry.eol) {
+		return &centry, false, true
+	}
+
+	// If it's expired but the record it came from is still valid, it can
+	// be served while it is refreshed
+	if r.staleWhileRevalidate && centry.err == nil && (centry.recordEol == nil || now.Before(*centry.recordEol)) {
+		return &centry, true, true
 	}
 
 	// It's expired, so remove it
 	r.cache.Remove(k)
 
-	return nil, false
+	return nil, false, false
 }
 
-func (r *ResolverCache) cacheSet(k string, val []byte, eol *time.Time) {
+func (r *ResolverCache) cacheSet(k string, val []byte, eol *time.Time, record *RecordInfo) {
 	if r.cache == nil {
 		return
 	}
@@ -88,25 +174,186 @@ func (r *ResolverCache) cacheSet(k string, val []byte, eol *time.Time) {
 	}
 
 	r.cache.Add(k, cacheEntry{
-		val: val,
-		eol: cacheTill,
+		val:       val,
+		eol:       cacheTill,
+		recordEol: eol,
+		record:    record,
+	})
+}
+
+// Caches a failed lookup, so that lookups for names that don't exist or
+// whose records fail verification are not repeated on every request. If
+// negative caching is disabled, removes any stale entry for the key.
+func (r *ResolverCache) cacheSetError(k string, err error) {
+	if r.cache == nil {
+		return
+	}
+	if r.negativeTTL <= 0 {
+		r.cache.Remove(k)
+		return
+	}
+
+	r.cache.Add(k, cacheEntry{
+		eol: time.Now().Add(r.negativeTTL),
+		err: err,
 	})
 }
 
 func (r *ResolverCache) GetValue(ctx context.Context, k string) ([]byte, error) {
+	hop := hopFromContext(ctx)
+
 	// Check the cache
-	val, ok := r.cacheGet(k)
+	centry, stale, ok := r.cacheGet(k)
 	if ok {
-		log.Debugf("Found %s in cache: %s", k, val)
-		return val, nil
+		if hop != nil {
+			hop.CacheHit = true
+			hop.Stale = stale
+			hop.Record = centry.record
+		}
+		if centry.err != nil {
+			log.Debugf("Found failed lookup for %s in cache: %s", k, centry.err)
+			return nil, centry.err
+		}
+		if stale {
+			log.Debugf("Found stale entry for %s in cache: %s", k, centry.val)
+			r.refresh(k)
+		} else {
+			log.Debugf("Found %s in cache: %s", k, centry.val)
+		}
+		return centry.val, nil
 	}
 
 	// Not in the cache, go out to the resolver
-	val, eol, err := r.vg.GetValue(ctx, k)
+	if hop == nil {
+		hop = &ResolveHop{}
+	}
+	return r.fetch(ctx, k, hop)
+}
+
+// Gets the value from the resolver and caches the result. If there is
+// already a lookup in progress for the key, waits for it and shares its
+// result, including any error.
+func (r *ResolverCache) fetch(ctx context.Context, k string, hop *ResolveHop) ([]byte, error) {
+	for {
+		r.lk.Lock()
+		c, ok := r.inflight[k]
+		if !ok {
+			c = &fetchCall{done: make(chan struct{})}
+			r.inflight[k] = c
+			r.lk.Unlock()
+
+			r.doFetch(ctx, k, hop, c)
+			return c.val, c.err
+		}
+		r.lk.Unlock()
+
+		log.Debugf("Waiting for lookup of %s already in progress", k)
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// If the lookup failed because the context of the caller that
+		// made it was cancelled, try again with this caller's context
+		if c.cancelled && ctx.Err() == nil {
+			continue
+		}
+
+		hop.Record = c.record
+		return c.val, c.err
+	}
+}
+
+// Performs the lookup and caches the result. The resolver fills in the
+// details of the record the value came from in the hop, which are cached
+// with the value so that they can be traced on a cache hit.
+func (r *ResolverCache) doFetch(ctx context.Context, k string, hop *ResolveHop, c *fetchCall) {
+	defer func() {
+		r.lk.Lock()
+		if r.inflight[k] == c {
+			delete(r.inflight, k)
+		}
+		r.lk.Unlock()
+		close(c.done)
+	}()
+
+	val, eol, err := r.vg.GetValue(contextWithHop(ctx, hop), k)
+	negative := false
+	if ne, ok := err.(*negativeError); ok {
+		err = ne.err
+		negative = true
+	}
+	c.record = hop.Record
+	c.val = val
+	c.err = err
 	if err != nil {
-		return nil, err
+		c.cancelled = ctx.Err() != nil || err == context.Canceled || err == context.DeadlineExceeded
+	}
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if c.invalidated {
+		return
 	}
 
-	r.cacheSet(k, val, eol)
-	return val, nil
+	if err != nil {
+		// Only failures that the resolver reports are not likely to be
+		// transient are cached. They replace any stale entry, so that
+		// eg a record whose certificate has been revoked is no longer
+		// served. After other failures (eg because we're offline) a
+		// stale entry is still served until the EOL of its record.
+		if negative && !c.cancelled {
+			r.cacheSetError(k, err)
+		}
+		return
+	}
+
+	r.cacheSet(k, val, eol, hop.Record)
+}
+
+// Invalidate removes the entry for the key from the cache (including the
+// persistent cache, if there is one), so that the next lookup goes out to
+// the resolver. The result of a lookup already in progress is not cached.
+func (r *ResolverCache) Invalidate(k string) error {
+	log.Debugf("Invalidating cache entry for %s", k)
+
+	r.lk.Lock()
+	if c, ok := r.inflight[k]; ok {
+		c.invalidated = true
+		delete(r.inflight, k)
+	}
+	if r.cache != nil {
+		r.cache.Remove(k)
+	}
+	r.lk.Unlock()
+
+	if dc, ok := r.vg.(*DatastoreCache); ok {
+		return dc.Invalidate(k)
+	}
+	return nil
+}
+
+// Refreshes the entry for the key in the background. If there is
+// already a lookup in progress for the key, does nothing.
+func (r *ResolverCache) refresh(k string) {
+	r.lk.Lock()
+	if _, ok := r.inflight[k]; ok {
+		r.lk.Unlock()
+		return
+	}
+	c := &fetchCall{done: make(chan struct{})}
+	r.inflight[k] = c
+	r.lk.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), CacheRefreshTimeout)
+		defer cancel()
+
+		log.Debugf("Refreshing stale cache entry for %s", k)
+		r.doFetch(ctx, k, &ResolveHop{}, c)
+		if c.err != nil {
+			log.Warningf("Failed to refresh stale cache entry for %s: %s", k, c.err)
+		}
+	}()
 }
@@ -2,6 +2,8 @@ package iprs_resolver
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -24,7 +26,7 @@ func getEolRecord(t *testing.T, c *cid.Cid, ts time.Time, r routing.ValueStore)
 	}
 	vl := rec.NewEolRecordValidation(ts)
 	s := rec.NewKeyRecordSigner(pk)
-	record, err := rec.NewRecord(vl, s, c.Bytes())
+	record, err := rec.NewRecord(vl, s, c.Bytes(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,7 +57,7 @@ func TestCacheSizeZero(t *testing.T) {
 	publisher.Publish(ctx, iprsKey, eolRecord)
 
 	// Get the entry value (cache is size zero so it will be retrieved from routing)
-	rs := NewIprsResolver(nil, r, dag, &CacheOpts{0, nil})
+	rs := NewIprsResolver(nil, r, dag, &CacheOpts{size: 0})
 	res, _, err := rs.Resolve(ctx, iprsKey.String())
 	if err != nil {
 		t.Fatal(err)
@@ -88,7 +90,7 @@ func TestCacheSizeTen(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	id := testutil.RandIdentityOrFatal(t)
 	r := tu.NewMockValueStore(context.Background(), id, dstore)
-	rs := NewIprsResolver(nil, r, dag, &CacheOpts{10, nil})
+	rs := NewIprsResolver(nil, r, dag, &CacheOpts{size: 10})
 	publisher := psh.NewDHTPublisher(r, dag)
 
 	ts := time.Now().Add(time.Hour)
@@ -140,7 +142,7 @@ func TestCacheEolExpired(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	id := testutil.RandIdentityOrFatal(t)
 	r := tu.NewMockValueStore(context.Background(), id, dstore)
-	rs := NewIprsResolver(nil, r, dag, &CacheOpts{10, nil})
+	rs := NewIprsResolver(nil, r, dag, &CacheOpts{size: 10})
 	publisher := psh.NewDHTPublisher(r, dag)
 
 	ts := time.Now().Add(time.Millisecond * 100)
@@ -188,7 +190,7 @@ func TestCacheTimeRangeExpired(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	id := testutil.RandIdentityOrFatal(t)
 	r := tu.NewMockValueStore(context.Background(), id, dstore)
-	rs := NewIprsResolver(nil, r, dag, &CacheOpts{10, nil})
+	rs := NewIprsResolver(nil, r, dag, &CacheOpts{size: 10})
 	publisher := psh.NewDHTPublisher(r, dag)
 
 	pk, _, err := testutil.RandTestKeyPair(512)
@@ -207,7 +209,7 @@ func TestCacheTimeRangeExpired(t *testing.T) {
 		t.Fatal(err)
 	}
 	s := rec.NewKeyRecordSigner(pk)
-	rangeRecord, err := rec.NewRecord(vl, s, c.Bytes())
+	rangeRecord, err := rec.NewRecord(vl, s, c.Bytes(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -247,3 +249,374 @@ func TestCacheTimeRangeExpired(t *testing.T) {
 		t.Fatal("Expected key not found error")
 	}
 }
+
+func TestNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(context.Background(), id, dstore)
+	publisher := psh.NewDHTPublisher(r, dag)
+
+	negativeTTL := time.Millisecond * 100
+	rs := NewIprsResolver(nil, r, dag, &CacheOpts{size: 10, negativeTTL: &negativeTTL})
+	disabled := time.Duration(0)
+	nrs := NewIprsResolver(nil, r, dag, &CacheOpts{size: 10, negativeTTL: &disabled})
+
+	ts := time.Now().Add(time.Hour)
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, eolRecord := getEolRecord(t, c, ts, r)
+
+	// The record hasn't been published yet
+	for _, res := range []*IprsResolver{rs, nrs} {
+		_, _, err = res.Resolve(ctx, iprsKey.String())
+		if err == nil {
+			t.Fatal("Expected key not found error")
+		}
+	}
+
+	err = publisher.Publish(ctx, iprsKey, eolRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed lookup should still be in the cache
+	_, _, err = rs.Resolve(ctx, iprsKey.String())
+	if err == nil {
+		t.Fatal("Expected failed lookup to be cached")
+	}
+
+	// Unless negative caching is disabled
+	_, _, err = nrs.Resolve(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the negative TTL has passed the record should resolve
+	time.Sleep(negativeTTL + time.Millisecond)
+	res, _, err := rs.Resolve(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resc, err := cid.Parse([]byte(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resc.Equals(c) {
+		t.Fatal("Got back incorrect value")
+	}
+}
+
+func TestNegativeCacheOnlyCachesDefinitiveFailures(t *testing.T) {
+	ctx := context.Background()
+	vg := &mockValueGetter{}
+	negativeTTL := time.Hour
+	rc := NewResolverCache(vg, &CacheOpts{size: 10, negativeTTL: &negativeTTL})
+
+	// A transient failure (eg the network is down) should not be cached
+	transient := errors.New("network unreachable")
+	vg.set(nil, nil, transient, nil)
+	_, err := rc.GetValue(ctx, "transient")
+	if err != transient {
+		t.Fatalf("Expected %v, got %v", transient, err)
+	}
+	vg.set([]byte("value"), nil, nil, nil)
+	val, err := rc.GetValue(ctx, "transient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "value" {
+		t.Fatalf("Expected value, got %s", val)
+	}
+
+	// A failure that the value getter reports is not transient should be
+	// cached, and the underlying error returned
+	vg.set(nil, nil, negativeErr(ErrResolveFailed), nil)
+	_, err = rc.GetValue(ctx, "missing")
+	if err != ErrResolveFailed {
+		t.Fatalf("Expected ErrResolveFailed, got %v", err)
+	}
+	vg.set([]byte("value"), nil, nil, nil)
+	calls := vg.callCount()
+	_, err = rc.GetValue(ctx, "missing")
+	if err != ErrResolveFailed {
+		t.Fatalf("Expected cached ErrResolveFailed, got %v", err)
+	}
+	if vg.callCount() != calls {
+		t.Fatal("Expected failed lookup to be served from the cache")
+	}
+
+	// Negative caching is disabled by default
+	rc = NewResolverCache(vg, &CacheOpts{size: 10})
+	vg.set(nil, nil, negativeErr(ErrResolveFailed), nil)
+	_, err = rc.GetValue(ctx, "missing")
+	if err != ErrResolveFailed {
+		t.Fatalf("Expected ErrResolveFailed, got %v", err)
+	}
+	vg.set([]byte("value"), nil, nil, nil)
+	_, err = rc.GetValue(ctx, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A ValueGetter that returns a fixed result, and counts how many times
+// it is called
+type mockValueGetter struct {
+	lk    sync.Mutex
+	calls int
+	val   []byte
+	eol   *time.Time
+	err   error
+	// If not nil, GetValue blocks until it is closed
+	block chan struct{}
+}
+
+func (m *mockValueGetter) GetValue(ctx context.Context, k string) ([]byte, *time.Time, error) {
+	m.lk.Lock()
+	m.calls++
+	val, eol, err, block := m.val, m.eol, m.err, m.block
+	m.lk.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	return val, eol, err
+}
+
+func (m *mockValueGetter) set(val []byte, eol *time.Time, err error, block chan struct{}) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.val, m.eol, m.err, m.block = val, eol, err, block
+}
+
+func (m *mockValueGetter) callCount() int {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.calls
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	ttl := time.Millisecond * 50
+	negativeTTL := time.Hour
+	vg := &mockValueGetter{}
+	rc := NewResolverCache(vg, &CacheOpts{size: 10, ttl: &ttl, negativeTTL: &negativeTTL, staleWhileRevalidate: true})
+
+	var checkValue = func(expected string) {
+		val, err := rc.GetValue(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != expected {
+			t.Fatalf("Expected %s, got %s", expected, val)
+		}
+	}
+
+	eol := time.Now().Add(time.Hour)
+	vg.set([]byte("old"), &eol, nil, nil)
+	checkValue("old")
+
+	// Wait for the entry to become stale. While the refresh is blocked
+	// the stale value should be served, and there should only be one
+	// refresh
+	time.Sleep(ttl + time.Millisecond)
+	block := make(chan struct{})
+	vg.set([]byte("new"), &eol, nil, block)
+	for i := 0; i < 5; i++ {
+		checkValue("old")
+	}
+	close(block)
+
+	// Wait for the refresh to complete
+	for i := 0; ; i++ {
+		val, err := rc.GetValue(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) == "new" {
+			break
+		}
+		if i > 100 {
+			t.Fatal("Stale entry was not refreshed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if vg.callCount() != 2 {
+		t.Fatalf("Expected 2 calls to value getter, got %d", vg.callCount())
+	}
+
+	// If the refresh fails with a transient error (eg because we're
+	// offline), the stale entry should still be served
+	var waitForCalls = func(vg *mockValueGetter, n int) {
+		for i := 0; vg.callCount() != n; i++ {
+			if i > 100 {
+				t.Fatal("Stale entry was not refreshed")
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	time.Sleep(ttl + time.Millisecond)
+	vg.set(nil, nil, errors.New("offline"), nil)
+	checkValue("new")
+	waitForCalls(vg, 3)
+
+	// If the refresh fails because the name was not found or its record
+	// failed verification (eg because its certificate was revoked), the
+	// stale entry is replaced by the failure
+	vg.set(nil, nil, negativeErr(ErrResolveFailed), nil)
+	checkValue("new")
+	waitForCalls(vg, 4)
+	_, err := rc.GetValue(ctx, "key")
+	if err != ErrResolveFailed {
+		t.Fatalf("Expected ErrResolveFailed, got %v", err)
+	}
+	if vg.callCount() != 4 {
+		t.Fatalf("Expected failure to be cached, got %d calls to value getter", vg.callCount())
+	}
+
+	// Without negative caching the stale entry is removed
+	nvg := &mockValueGetter{}
+	nrc := NewResolverCache(nvg, &CacheOpts{size: 10, ttl: &ttl, staleWhileRevalidate: true})
+	nvg.set([]byte("old"), &eol, nil, nil)
+	_, err = nrc.GetValue(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(ttl + time.Millisecond)
+	nvg.set(nil, nil, negativeErr(ErrResolveFailed), nil)
+	_, err = nrc.GetValue(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCalls(nvg, 2)
+	_, err = nrc.GetValue(ctx, "key")
+	if err != ErrResolveFailed {
+		t.Fatalf("Expected ErrResolveFailed, got %v", err)
+	}
+
+	// An entry whose record has expired should not be served
+	expiry := time.Now().Add(ttl)
+	vg.set([]byte("expiring"), &expiry, nil, nil)
+	_, err = rc.GetValue(ctx, "expiring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(ttl + time.Millisecond)
+	vg.set(nil, nil, errors.New("not found"), nil)
+	_, err = rc.GetValue(ctx, "expiring")
+	if err == nil {
+		t.Fatal("Expected expired entry not to be served")
+	}
+}
+
+func TestCacheCoalescesLookups(t *testing.T) {
+	ctx := context.Background()
+	vg := &mockValueGetter{}
+	// Lookups should be coalesced even if caching is disabled
+	rc := NewResolverCache(vg, &CacheOpts{size: 0})
+
+	// Makes concurrent lookups that are blocked until the value getter
+	// is unblocked, and checks that they share the result of one lookup
+	var checkCoalesced = func(val []byte, lookupErr error) {
+		block := make(chan struct{})
+		vg.set(val, nil, lookupErr, block)
+		calls := vg.callCount()
+
+		count := 100
+		type result struct {
+			val []byte
+			err error
+		}
+		results := make(chan result, count)
+		for i := 0; i < count; i++ {
+			go func() {
+				v, err := rc.GetValue(ctx, "key")
+				results <- result{v, err}
+			}()
+		}
+
+		// Give the lookups a chance to start before unblocking them
+		time.Sleep(time.Millisecond * 50)
+		close(block)
+
+		for i := 0; i < count; i++ {
+			res := <-results
+			if string(res.val) != string(val) || res.err != lookupErr {
+				t.Fatalf("Expected (%s, %v), got (%s, %v)", val, lookupErr, res.val, res.err)
+			}
+		}
+		if vg.callCount()-calls != 1 {
+			t.Fatalf("Expected 1 call to value getter, got %d", vg.callCount()-calls)
+		}
+	}
+
+	checkCoalesced([]byte("value"), nil)
+	checkCoalesced(nil, errors.New("not found"))
+
+	// Once the lookup has completed, the next lookup goes out to the
+	// value getter again
+	vg.set([]byte("value"), nil, nil, nil)
+	calls := vg.callCount()
+	_, err := rc.GetValue(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vg.callCount()-calls != 1 {
+		t.Fatal("Expected a new call to value getter")
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	vg := &mockValueGetter{}
+	rc := NewResolverCache(vg, &CacheOpts{size: 10})
+
+	var checkValue = func(expected string) {
+		val, err := rc.GetValue(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != expected {
+			t.Fatalf("Expected %s, got %s", expected, val)
+		}
+	}
+
+	vg.set([]byte("old"), nil, nil, nil)
+	checkValue("old")
+
+	// The cached value is returned until the key is invalidated
+	vg.set([]byte("new"), nil, nil, nil)
+	checkValue("old")
+	err := rc.Invalidate("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue("new")
+
+	// If the key is invalidated while a lookup is in progress, the
+	// result of the lookup should not be cached
+	block := make(chan struct{})
+	vg.set([]byte("in progress"), nil, nil, block)
+	rc.Invalidate("key")
+	done := make(chan []byte)
+	go func() {
+		val, _ := rc.GetValue(ctx, "key")
+		done <- val
+	}()
+	time.Sleep(time.Millisecond * 50)
+	err = rc.Invalidate("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vg.set([]byte("newest"), nil, nil, nil)
+	close(block)
+	if val := <-done; string(val) != "in progress" {
+		t.Fatalf("Expected in progress, got %s", val)
+	}
+	checkValue("newest")
+}
@@ -3,7 +3,6 @@ package iprs_resolver
 import (
 	"context"
 	"fmt"
-	"net"
 	"strings"
 	"time"
 
@@ -13,28 +12,38 @@ import (
 
 const DefaultDnsCacheTTL = time.Minute
 
-type LookupTXTFunc func(name string) (txt []string, err error)
-
 // DNSResolver implements a Resolver on DNS domains
 type DNSResolver struct {
-	parent    *Resolver
-	cache     *ResolverCache
-	lookupTXT LookupTXTFunc
+	parent *Resolver
+	cache  *ResolverCache
+	lookup TXTLookup
+	// In DNSSEC strict mode only the _dnslink TXT records are used, ie
+	// there is no fallback to the TXT records of the domain itself
+	strict bool
 }
 
-// NewDNSResolver constructs a name resolver using DNS TXT records.
-func NewDNSResolver(parent *Resolver, opts *CacheOpts) *DNSResolver {
+// NewDNSResolver constructs a name resolver using DNS TXT records. If
+// lookup is nil, TXT records are looked up with the system resolver.
+// In DNSSEC strict mode (ie if lookup is a DNSSECLookup) only the _dnslink
+// TXT records are used, as a forged denial of existence for the _dnslink
+// name could otherwise redirect resolution to the domain's own records.
+func NewDNSResolver(parent *Resolver, opts *CacheOpts, lookup TXTLookup) *DNSResolver {
 	if opts == nil {
 		ttl := DefaultDnsCacheTTL
-		opts = &CacheOpts{10, &ttl}
+		opts = &CacheOpts{size: 10, ttl: &ttl}
+	}
+	if lookup == nil {
+		lookup = SystemTXTLookup
 	}
-	rs := DNSResolver{parent: parent, lookupTXT: net.LookupTXT}
+	_, strict := lookup.(*DNSSECLookup)
+	rs := DNSResolver{parent: parent, lookup: lookup, strict: strict}
 	rs.cache = NewResolverCache(&rs, opts)
 	return &rs
 }
 
 type lookupRes struct {
 	path  string
+	ttl   time.Duration
 	error error
 }
 
@@ -62,14 +71,25 @@ func (r *DNSResolver) Resolve(ctx context.Context, p string) (string, []string,
 	return string(val), parts[3:], nil
 }
 
+// Invalidate removes the cached value for the path
+func (r *DNSResolver) Invalidate(p string) error {
+	if !r.Accept(p) {
+		return fmt.Errorf("DNS resolver cannot invalidate %s", p)
+	}
+	return r.cache.Invalidate(strings.Split(p, "/")[2])
+}
+
 func (r *DNSResolver) GetValue(ctx context.Context, domain string) ([]byte, *time.Time, error) {
 	log.Debugf("DNSResolver resolving %s", domain)
 
-	rootChan := make(chan lookupRes, 1)
-	go workDomain(r, domain, rootChan)
+	var rootChan chan lookupRes
+	if !r.strict {
+		rootChan = make(chan lookupRes, 1)
+		go workDomain(ctx, r, domain, rootChan)
+	}
 
 	subChan := make(chan lookupRes, 1)
-	go workDomain(r, "_dnslink."+domain, subChan)
+	go workDomain(ctx, r, "_dnslink."+domain, subChan)
 
 	var subRes lookupRes
 	select {
@@ -79,7 +99,13 @@ func (r *DNSResolver) GetValue(ctx context.Context, domain string) ([]byte, *tim
 	}
 
 	if subRes.error == nil {
-		return []byte(subRes.path), nil, nil
+		return []byte(subRes.path), ttlToEol(subRes.ttl), nil
+	}
+	if r.strict {
+		if isDefinitiveDNSError(subRes.error) {
+			return nil, nil, negativeErr(ErrResolveFailed)
+		}
+		return nil, nil, ErrResolveFailed
 	}
 
 	var rootRes lookupRes
@@ -89,30 +115,54 @@ func (r *DNSResolver) GetValue(ctx context.Context, domain string) ([]byte, *tim
 		return nil, nil, ctx.Err()
 	}
 	if rootRes.error == nil {
-		return []byte(rootRes.path), nil, nil
+		return []byte(rootRes.path), ttlToEol(rootRes.ttl), nil
 	}
 
+	// The failure can be cached if neither name has a valid dnslink
+	// record, as opposed to eg the DNS server being unreachable
+	if isDefinitiveDNSError(subRes.error) && isDefinitiveDNSError(rootRes.error) {
+		return nil, nil, negativeErr(ErrResolveFailed)
+	}
 	return nil, nil, ErrResolveFailed
 }
 
-func workDomain(r *DNSResolver, name string, res chan lookupRes) {
-	txt, err := r.lookupTXT(name)
+// Checks if a lookup failed because the name has no valid dnslink record
+// (or its records failed DNSSEC validation), rather than a transient error
+func isDefinitiveDNSError(err error) bool {
+	switch err {
+	case ErrNoTXTRecords, ErrResolveFailed, ErrDNSSECBogus, ErrDNSSECUnsigned:
+		return true
+	}
+	return false
+}
+
+// The value is cached until the TTL of the DNS records expires
+func ttlToEol(ttl time.Duration) *time.Time {
+	if ttl == UnknownTTL {
+		return nil
+	}
+	eol := time.Now().Add(ttl)
+	return &eol
+}
+
+func workDomain(ctx context.Context, r *DNSResolver, name string, res chan lookupRes) {
+	txt, ttl, err := r.lookup.LookupTXT(ctx, name)
 	if err != nil {
 		// Error is != nil
-		res <- lookupRes{"", err}
+		res <- lookupRes{"", UnknownTTL, err}
 		return
 	}
 
-	log.Debugf("DNSResolver lookupTXT(%s) => %s", name, txt)
+	log.Debugf("DNSResolver LookupTXT(%s) => %s (TTL %s)", name, txt, ttl)
 	for _, t := range txt {
 		p, err := r.parseEntry(t)
 		if err == nil {
-			res <- lookupRes{p, nil}
+			res <- lookupRes{p, ttl, nil}
 			return
 		}
 		log.Debugf("Could not parse entry %s", t)
 	}
-	res <- lookupRes{"", ErrResolveFailed}
+	res <- lookupRes{"", UnknownTTL, ErrResolveFailed}
 }
 
 func (r *DNSResolver) parseEntry(txt string) (string, error) {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	tu "github.com/dirkmc/go-iprs/test"
 	dstest "github.com/ipfs/go-ipfs/merkledag/test"
@@ -19,12 +20,12 @@ type mockDNS struct {
 	entries map[string][]string
 }
 
-func (m *mockDNS) lookupTXT(name string) (txt []string, err error) {
+func (m *mockDNS) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
 	txt, ok := m.entries[name]
 	if !ok {
-		return nil, fmt.Errorf("No TXT entry for %s", name)
+		return nil, UnknownTTL, fmt.Errorf("No TXT entry for %s", name)
 	}
-	return txt, nil
+	return txt, UnknownTTL, nil
 }
 
 func TestDNSEntryParsing(t *testing.T) {
@@ -168,7 +169,7 @@ func TestDNSResolution(t *testing.T) {
 	vs := tu.NewMockValueStore(context.Background(), id, dstore)
 	r := NewResolver(vs, dag, NoCacheOpts)
 	mock := newMockDNS()
-	dns := &DNSResolver{parent: r, lookupTXT: mock.lookupTXT}
+	dns := &DNSResolver{parent: r, lookup: mock}
 	dns.cache = NewResolverCache(dns, nil)
 	r.resolvers[0] = dns
 
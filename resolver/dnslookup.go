@@ -0,0 +1,116 @@
+package iprs_resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	dns "github.com/miekg/dns"
+)
+
+// UnknownTTL is returned by a TXTLookup that cannot determine the TTL of
+// the records it looks up. Values with an unknown TTL are cached for the
+// resolver cache TTL.
+const UnknownTTL = time.Duration(-1)
+
+// ErrNoTXTRecords is returned by a TXTLookup when the name does not exist
+// or has no TXT records
+var ErrNoTXTRecords = errors.New("No TXT records found")
+
+// TXTLookup looks up the DNS TXT records for a name
+type TXTLookup interface {
+	// LookupTXT returns the TXT records for the name, and the TTL of
+	// the records (or UnknownTTL)
+	LookupTXT(ctx context.Context, name string) (txt []string, ttl time.Duration, err error)
+}
+
+// TXTLookupFunc is an adapter that allows a function to be used as a
+// TXTLookup
+type TXTLookupFunc func(ctx context.Context, name string) ([]string, time.Duration, error)
+
+func (f TXTLookupFunc) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	return f(ctx, name)
+}
+
+// SystemTXTLookup looks up TXT records with the system resolver. The
+// system resolver doesn't report TTLs.
+var SystemTXTLookup = TXTLookupFunc(func(ctx context.Context, name string) ([]string, time.Duration, error) {
+	txt, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.Err == "no such host" {
+		log.Debugf("No TXT records found for %s: %s", name, err)
+		return nil, UnknownTTL, ErrNoTXTRecords
+	}
+	return txt, UnknownTTL, err
+})
+
+// DNSExchanger sends DNS queries and returns the responses. It is
+// implemented by DNSClientLookup and DoHLookup.
+type DNSExchanger interface {
+	Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
+}
+
+// DNSClientLookup looks up TXT records by querying a DNS server directly,
+// so that the TTL of the records is known
+type DNSClientLookup struct {
+	client *dns.Client
+	server string
+}
+
+// NewDNSClientLookup creates a TXTLookup that queries the DNS server at
+// the given address (eg "8.8.8.8:53")
+func NewDNSClientLookup(server string) *DNSClientLookup {
+	return &DNSClientLookup{new(dns.Client), server}
+}
+
+func (l *DNSClientLookup) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.RecursionDesired = true
+
+	in, err := l.Exchange(ctx, m)
+	if err != nil {
+		return nil, UnknownTTL, err
+	}
+	return txtAnswer(name, in)
+}
+
+// Exchange sends the query to the DNS server and returns the response
+func (l *DNSClientLookup) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	in, _, err := l.client.ExchangeContext(ctx, m, l.server)
+	return in, err
+}
+
+// Gets the TXT records and their TTL from a DNS response. The TTL is the
+// lowest TTL of the records.
+func txtAnswer(name string, in *dns.Msg) ([]string, time.Duration, error) {
+	if in.Rcode == dns.RcodeNameError {
+		log.Debugf("No TXT records found for %s: name does not exist", name)
+		return nil, UnknownTTL, ErrNoTXTRecords
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return nil, UnknownTTL, fmt.Errorf("DNS lookup of TXT records for %s failed: %s", name, dns.RcodeToString[in.Rcode])
+	}
+
+	var txt []string
+	ttl := UnknownTTL
+	for _, rr := range in.Answer {
+		t, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		// Long TXT records are split into several strings
+		txt = append(txt, strings.Join(t.Txt, ""))
+		rrttl := time.Duration(t.Hdr.Ttl) * time.Second
+		if ttl == UnknownTTL || rrttl < ttl {
+			ttl = rrttl
+		}
+	}
+	if len(txt) == 0 {
+		log.Debugf("No TXT records found for %s", name)
+		return nil, UnknownTTL, ErrNoTXTRecords
+	}
+	return txt, ttl, nil
+}
@@ -0,0 +1,135 @@
+package iprs_resolver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	dns "github.com/miekg/dns"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+// An in-process DNS server that answers TXT queries
+type fakeDNSServer struct {
+	lk      sync.Mutex
+	entries map[string][]string
+	ttl     uint32
+	server  *dns.Server
+}
+
+func startFakeDNSServer(t *testing.T, entries map[string][]string, ttl uint32) *fakeDNSServer {
+	f := &fakeDNSServer{entries: entries, ttl: ttl}
+	f.server = startDNSServer(t, dns.HandlerFunc(f.serveDNS))
+	return f
+}
+
+// Starts a DNS server on a local UDP port
+func startDNSServer(t *testing.T, handler dns.Handler) *dns.Server {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		Handler:           handler,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go server.ActivateAndServe()
+	<-started
+	return server
+}
+
+func (f *fakeDNSServer) addr() string {
+	return f.server.PacketConn.LocalAddr().String()
+}
+
+func (f *fakeDNSServer) set(name string, txt []string) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.entries[name] = txt
+}
+
+func (f *fakeDNSServer) serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	q := req.Question[0]
+	txt, ok := f.entries[dns.Fqdn(q.Name)]
+	if !ok || q.Qtype != dns.TypeTXT {
+		m.SetRcode(req, dns.RcodeNameError)
+		w.WriteMsg(m)
+		return
+	}
+	m.Answer = append(m.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: f.ttl},
+		Txt: txt,
+	})
+	w.WriteMsg(m)
+}
+
+func TestDNSClientLookup(t *testing.T) {
+	ctx := context.Background()
+	f := startFakeDNSServer(t, map[string][]string{
+		"example.com.": []string{"dnslink=/ipfs/", "QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"},
+	}, 300)
+	defer f.server.Shutdown()
+
+	lookup := NewDNSClientLookup(f.addr())
+	txt, ttl, err := lookup.LookupTXT(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The strings that make up the TXT record should be joined
+	if len(txt) != 1 || txt[0] != "dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD" {
+		t.Fatalf("Unexpected TXT records %s", txt)
+	}
+	if ttl != time.Second*300 {
+		t.Fatalf("Expected TTL of 300s, got %s", ttl)
+	}
+
+	_, _, err = lookup.LookupTXT(ctx, "missing.example.com")
+	if err == nil {
+		t.Fatal("Expected error looking up missing name")
+	}
+}
+
+func TestDNSResolverHonoursTTL(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	vs := tu.NewMockValueStore(context.Background(), id, dstore)
+
+	f := startFakeDNSServer(t, map[string][]string{
+		"_dnslink.example.com.": []string{"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"},
+	}, 1)
+	defer f.server.Shutdown()
+
+	// The cache TTL is much longer than the TTL of the DNS record
+	opts, err := NewResolverOpts(
+		WithCache(NamespaceDns, 10, time.Hour),
+		WithDNSLookup(NewDNSClientLookup(f.addr())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver(vs, dag, opts)
+
+	testResolution(t, r, "/ipns/example.com", DefaultDepthLimit, "QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+
+	// The value should be cached until the TTL of the DNS record expires
+	f.set("_dnslink.example.com.", []string{"dnslink=/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy"})
+	testResolution(t, r, "/ipns/example.com", DefaultDepthLimit, "QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+
+	time.Sleep(time.Second + time.Millisecond*100)
+	testResolution(t, r, "/ipns/example.com", DefaultDepthLimit, "QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy", nil)
+}
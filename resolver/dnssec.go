@@ -0,0 +1,245 @@
+package iprs_resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	dns "github.com/miekg/dns"
+)
+
+// ErrDNSSECUnsigned is returned in DNSSEC strict mode when an answer (or
+// a zone in its chain of trust) is not signed
+var ErrDNSSECUnsigned = errors.New("DNS answer is not signed with DNSSEC")
+
+// ErrDNSSECBogus is returned in DNSSEC strict mode when an answer cannot
+// be validated against the trust anchors
+var ErrDNSSECBogus = errors.New("DNS answer failed DNSSEC validation")
+
+// DNSSECLookup looks up TXT records and validates them with DNSSEC, so
+// that an on-path attacker cannot redirect a name. Answers are only
+// accepted if there is a chain of trust from a configured trust anchor
+// to the TXT RRset. Unsigned and bogus answers are rejected.
+// Denial of existence (NXDOMAIN or no TXT records) is not validated, so the
+// DNS resolver doesn't fall back from the _dnslink name to the domain
+// itself when it uses a DNSSECLookup.
+type DNSSECLookup struct {
+	ex DNSExchanger
+	// Trust anchors (DS or DNSKEY records), by zone
+	anchors map[string][]dns.RR
+}
+
+// NewDNSSECLookup creates a TXTLookup that sends queries with the
+// exchanger (eg a DNSClientLookup or DoHLookup) and validates the answers
+// against the trust anchors. Each trust anchor is a DS or DNSKEY record,
+// eg the DS record of the root zone.
+func NewDNSSECLookup(ex DNSExchanger, anchors []dns.RR) (*DNSSECLookup, error) {
+	if len(anchors) == 0 {
+		return nil, fmt.Errorf("DNSSEC validation requires at least one trust anchor")
+	}
+	byZone := make(map[string][]dns.RR)
+	for _, a := range anchors {
+		switch a.(type) {
+		case *dns.DS, *dns.DNSKEY:
+		default:
+			return nil, fmt.Errorf("Invalid DNSSEC trust anchor %s. Expected a DS or DNSKEY record", a)
+		}
+		zone := canonicalName(a.Header().Name)
+		byZone[zone] = append(byZone[zone], a)
+	}
+	return &DNSSECLookup{ex, byZone}, nil
+}
+
+func (l *DNSSECLookup) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	owner := canonicalName(name)
+	in, err := l.query(ctx, owner, dns.TypeTXT)
+	if err != nil {
+		return nil, UnknownTTL, err
+	}
+	// Denial of existence isn't validated, so it could be forged. It is
+	// not reported as ErrNoTXTRecords, so that the failure isn't treated
+	// as definitive (eg negatively cached).
+	rrset, sigs := rrsetFromAnswer(in.Answer, owner, dns.TypeTXT)
+	if in.Rcode != dns.RcodeSuccess || len(rrset) == 0 {
+		return nil, UnknownTTL, fmt.Errorf("No TXT records found for %s (%s, denial of existence not validated)", name, dns.RcodeToString[in.Rcode])
+	}
+	if err = l.verifyRRset(ctx, owner, true, rrset, sigs); err != nil {
+		log.Warningf("DNSSEC validation of TXT records for %s failed: %s", name, err)
+		return nil, UnknownTTL, err
+	}
+	return txtAnswer(name, &dns.Msg{Answer: rrset})
+}
+
+// Verifies the RRset with the keys of the zone that signed it. The signer
+// must be an ancestor of the owner of the RRset, or the owner itself if
+// self is true.
+func (l *DNSSECLookup) verifyRRset(ctx context.Context, owner string, self bool, rrset []dns.RR, sigs []*dns.RRSIG) error {
+	if len(sigs) == 0 {
+		return ErrDNSSECUnsigned
+	}
+
+	var keyErr error
+	now := time.Now()
+	for _, sig := range sigs {
+		signer := canonicalName(sig.SignerName)
+		if !dns.IsSubDomain(signer, owner) || (!self && signer == owner) {
+			continue
+		}
+		// Wildcard expansions are rejected, as the proof that there is
+		// no closer match isn't checked
+		if int(sig.Labels) != dns.CountLabel(owner) || !sig.ValidityPeriod(now) {
+			continue
+		}
+
+		keys, err := l.zoneKeys(ctx, signer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			keyErr = err
+			continue
+		}
+		if verifyWithKeys(sig, keys, rrset) {
+			return nil
+		}
+	}
+
+	if keyErr != nil {
+		return keyErr
+	}
+	return ErrDNSSECBogus
+}
+
+// Gets the validated DNSKEYs of the zone
+func (l *DNSSECLookup) zoneKeys(ctx context.Context, zone string) ([]*dns.DNSKEY, error) {
+	in, err := l.query(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	rrset, sigs := rrsetFromAnswer(in.Answer, zone, dns.TypeDNSKEY)
+	if len(rrset) == 0 || len(sigs) == 0 {
+		return nil, ErrDNSSECUnsigned
+	}
+	keys := make([]*dns.DNSKEY, 0, len(rrset))
+	for _, rr := range rrset {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+
+	// The zone's keys must be signed by a key that matches either a
+	// trust anchor, or a DS record that the parent zone has signed
+	anchors, ok := l.anchors[zone]
+	if !ok {
+		if zone == "." {
+			log.Debugf("No DNSSEC trust anchor for the root zone")
+			return nil, ErrDNSSECBogus
+		}
+		anchors, err = l.delegation(ctx, zone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var trusted []*dns.DNSKEY
+	for _, k := range keys {
+		if matchesAnchor(k, anchors) {
+			trusted = append(trusted, k)
+		}
+	}
+
+	now := time.Now()
+	for _, sig := range sigs {
+		if canonicalName(sig.SignerName) != zone || !sig.ValidityPeriod(now) {
+			continue
+		}
+		if verifyWithKeys(sig, trusted, rrset) {
+			return keys, nil
+		}
+	}
+	return nil, ErrDNSSECBogus
+}
+
+// Gets the validated DS records for the zone from its parent zone
+func (l *DNSSECLookup) delegation(ctx context.Context, zone string) ([]dns.RR, error) {
+	in, err := l.query(ctx, zone, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+	rrset, sigs := rrsetFromAnswer(in.Answer, zone, dns.TypeDS)
+	if len(rrset) == 0 {
+		// An insecure delegation
+		return nil, ErrDNSSECUnsigned
+	}
+	if err = l.verifyRRset(ctx, zone, false, rrset, sigs); err != nil {
+		return nil, err
+	}
+	return rrset, nil
+}
+
+func (l *DNSSECLookup) query(ctx context.Context, name string, t uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, t)
+	m.RecursionDesired = true
+	// Ask for the signatures, and for the answer even if the upstream
+	// resolver thinks it is bogus, as it is validated here
+	m.CheckingDisabled = true
+	m.SetEdns0(4096, true)
+	return l.ex.Exchange(ctx, m)
+}
+
+// Gets the records of the given type for the owner from the answer, and
+// the signatures that cover them
+func rrsetFromAnswer(answer []dns.RR, owner string, t uint16) ([]dns.RR, []*dns.RRSIG) {
+	var rrset []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range answer {
+		if canonicalName(rr.Header().Name) != owner {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == t {
+				sigs = append(sigs, sig)
+			}
+		} else if rr.Header().Rrtype == t {
+			rrset = append(rrset, rr)
+		}
+	}
+	return rrset, sigs
+}
+
+func verifyWithKeys(sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) bool {
+	for _, k := range keys {
+		if k.Flags&dns.ZONE == 0 || k.Algorithm != sig.Algorithm || k.KeyTag() != sig.KeyTag {
+			continue
+		}
+		if sig.Verify(k, rrset) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the key matches one of the DS or DNSKEY records
+func matchesAnchor(k *dns.DNSKEY, anchors []dns.RR) bool {
+	for _, a := range anchors {
+		switch a := a.(type) {
+		case *dns.DNSKEY:
+			if a.Flags == k.Flags && a.Algorithm == k.Algorithm && a.PublicKey == k.PublicKey {
+				return true
+			}
+		case *dns.DS:
+			if a.KeyTag != k.KeyTag() || a.Algorithm != k.Algorithm {
+				continue
+			}
+			ds := k.ToDS(a.DigestType)
+			if ds != nil && strings.EqualFold(ds.Digest, a.Digest) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
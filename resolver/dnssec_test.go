@@ -0,0 +1,203 @@
+package iprs_resolver
+
+import (
+	"context"
+	"crypto"
+	"testing"
+	"time"
+
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	dns "github.com/miekg/dns"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+// A zone with a key that signs its records
+type signedZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSignedZone(t *testing.T, name string) *signedZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signedZone{name, key, priv.(crypto.Signer)}
+}
+
+func (z *signedZone) sign(t *testing.T, rrset ...dns.RR) *dns.RRSIG {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  z.key.Algorithm,
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(z.priv, rrset); err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func txtRecord(name string, txt string) *dns.TXT {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+		Txt: []string{txt},
+	}
+}
+
+// A DNS server that answers queries from a set of records
+type zoneServer map[string][]dns.RR
+
+func (zs zoneServer) add(rrs ...dns.RR) {
+	for _, rr := range rrs {
+		t := rr.Header().Rrtype
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			t = sig.TypeCovered
+		}
+		k := canonicalName(rr.Header().Name) + "/" + dns.TypeToString[t]
+		zs[k] = append(zs[k], rr)
+	}
+}
+
+func (zs zoneServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	q := req.Question[0]
+	rrs, ok := zs[canonicalName(q.Name)+"/"+dns.TypeToString[q.Qtype]]
+	if !ok {
+		m.SetRcode(req, dns.RcodeNameError)
+	}
+	m.Answer = rrs
+	w.WriteMsg(m)
+}
+
+func TestDNSSECLookup(t *testing.T) {
+	ctx := context.Background()
+
+	// com. delegates to example.com. with a signed DS record
+	com := newSignedZone(t, "com.")
+	example := newSignedZone(t, "example.com.")
+	exampleDS := example.key.ToDS(dns.SHA256)
+
+	valid := txtRecord("_dnslink.example.com.", "dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD")
+	bogus := txtRecord("_dnslink.bogus.example.com.", "dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD")
+	bogusSig := example.sign(t, bogus)
+	bogus.Txt = []string{"dnslink=/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy"}
+	unsigned := txtRecord("_dnslink.unsigned.example.com.", "dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD")
+
+	zs := make(zoneServer)
+	zs.add(com.key, com.sign(t, com.key))
+	zs.add(exampleDS, com.sign(t, exampleDS))
+	zs.add(example.key, example.sign(t, example.key))
+	zs.add(valid, example.sign(t, valid))
+	zs.add(bogus, bogusSig)
+	zs.add(unsigned)
+	server := startDNSServer(t, zs)
+	defer server.Shutdown()
+	client := NewDNSClientLookup(server.PacketConn.LocalAddr().String())
+
+	_, err := NewDNSSECLookup(client, []dns.RR{valid})
+	if err == nil {
+		t.Fatal("Expected error for trust anchor that is not a DS or DNSKEY record")
+	}
+
+	// Trust anchors can be DS records higher up the chain of trust, or
+	// the DNSKEY of the zone itself
+	anchors := [][]dns.RR{
+		[]dns.RR{com.key.ToDS(dns.SHA256)},
+		[]dns.RR{example.key},
+	}
+	for _, a := range anchors {
+		lookup, err := NewDNSSECLookup(client, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txt, _, err := lookup.LookupTXT(ctx, "_dnslink.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txt) != 1 || txt[0] != valid.Txt[0] {
+			t.Fatalf("Unexpected TXT records %s", txt)
+		}
+
+		_, _, err = lookup.LookupTXT(ctx, "_dnslink.bogus.example.com")
+		if err != ErrDNSSECBogus {
+			t.Fatalf("Expected ErrDNSSECBogus for tampered record, got %v", err)
+		}
+		_, _, err = lookup.LookupTXT(ctx, "_dnslink.unsigned.example.com")
+		if err != ErrDNSSECUnsigned {
+			t.Fatalf("Expected ErrDNSSECUnsigned for unsigned record, got %v", err)
+		}
+
+		// A denial of existence isn't validated, so it isn't reported as
+		// a definitive failure
+		_, _, err = lookup.LookupTXT(ctx, "_dnslink.missing.example.com")
+		if err == nil || err == ErrNoTXTRecords {
+			t.Fatalf("Expected unvalidated denial of existence error, got %v", err)
+		}
+	}
+
+	// A chain of trust that doesn't lead to the trust anchor is bogus
+	other := newSignedZone(t, "com.")
+	lookup, err := NewDNSSECLookup(client, []dns.RR{other.key.ToDS(dns.SHA256)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = lookup.LookupTXT(ctx, "_dnslink.example.com")
+	if err != ErrDNSSECBogus {
+		t.Fatalf("Expected ErrDNSSECBogus for wrong trust anchor, got %v", err)
+	}
+}
+
+func TestDNSResolverDNSSEC(t *testing.T) {
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	vs := tu.NewMockValueStore(context.Background(), id, dstore)
+
+	example := newSignedZone(t, "example.com.")
+	valid := txtRecord("_dnslink.example.com.", "dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD")
+	unsigned := txtRecord("_dnslink.unsigned.example.com.", "dnslink=/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy")
+	apex := txtRecord("apex.example.com.", "dnslink=/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy")
+
+	zs := make(zoneServer)
+	zs.add(example.key, example.sign(t, example.key))
+	zs.add(valid, example.sign(t, valid))
+	zs.add(unsigned)
+	zs.add(apex, example.sign(t, apex))
+	server := startDNSServer(t, zs)
+	defer server.Shutdown()
+	client := NewDNSClientLookup(server.PacketConn.LocalAddr().String())
+
+	// Strict mode needs a lookup that queries a DNS server
+	_, err := NewResolverOpts(WithDNSSEC(example.key))
+	if err == nil {
+		t.Fatal("Expected error for DNSSEC with the system lookup")
+	}
+
+	opts, err := NewResolverOpts(WithDNSSEC(example.key), WithDNSLookup(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver(vs, dag, opts)
+	testResolution(t, r, "/ipns/example.com", DefaultDepthLimit, "QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	testResolution(t, r, "/ipns/unsigned.example.com", DefaultDepthLimit, "", ErrResolveFailed)
+
+	// The denial of existence for _dnslink.apex.example.com can't be
+	// validated, so there is no fallback to the TXT records of the domain
+	// itself, even though they are signed
+	testResolution(t, r, "/ipns/apex.example.com", DefaultDepthLimit, "", ErrResolveFailed)
+}
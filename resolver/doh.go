@@ -0,0 +1,90 @@
+package iprs_resolver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	dns "github.com/miekg/dns"
+)
+
+// The media type of DNS wire format messages (RFC 8484)
+const dnsMessageType = "application/dns-message"
+
+// The maximum size of a DNS message
+const maxDNSMessageSize = 65535
+
+// DoHLookup looks up TXT records with DNS-over-HTTPS (RFC 8484), for
+// networks where plain DNS is blocked or can be spoofed
+type DoHLookup struct {
+	client   *http.Client
+	endpoint string
+}
+
+// NewDoHLookup creates a TXTLookup that sends queries to the DoH endpoint
+// (eg "https://cloudflare-dns.com/dns-query"). If client is nil,
+// http.DefaultClient is used.
+func NewDoHLookup(endpoint string, client *http.Client) *DoHLookup {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &DoHLookup{client, endpoint}
+}
+
+func (l *DoHLookup) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.RecursionDesired = true
+
+	in, err := l.Exchange(ctx, m)
+	if err != nil {
+		return nil, UnknownTTL, err
+	}
+	return txtAnswer(name, in)
+}
+
+// Exchange sends the query to the endpoint with a POST request and
+// returns the response
+func (l *DoHLookup) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	// The ID should be zero so that responses are cache friendly
+	m.Id = 0
+	q, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", l.endpoint, bytes.NewReader(q))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH query to %s failed with status %s", l.endpoint, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != dnsMessageType {
+		return nil, fmt.Errorf("DoH response from %s has unexpected content type %s", l.endpoint, ct)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	if err = in.Unpack(b); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
@@ -0,0 +1,120 @@
+package iprs_resolver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	dns "github.com/miekg/dns"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+// Creates a DoH server that answers TXT queries from the entries
+func newDoHServer(entries map[string][]string) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := new(dns.Msg)
+		if err = req.Unpack(b); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		txt, ok := entries[q.Name]
+		if ok && q.Qtype == dns.TypeTXT {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: txt,
+			})
+		} else {
+			m.SetRcode(req, dns.RcodeNameError)
+		}
+
+		resp, err := m.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(resp)
+	}))
+}
+
+func TestDoHLookup(t *testing.T) {
+	ctx := context.Background()
+	server := newDoHServer(map[string][]string{
+		"example.com.": []string{"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"},
+	})
+	defer server.Close()
+
+	lookup := NewDoHLookup(server.URL, server.Client())
+	txt, ttl, err := lookup.LookupTXT(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txt) != 1 || txt[0] != "dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD" {
+		t.Fatalf("Unexpected TXT records %s", txt)
+	}
+	if ttl != time.Minute {
+		t.Fatalf("Expected TTL of 60s, got %s", ttl)
+	}
+
+	_, _, err = lookup.LookupTXT(ctx, "missing.example.com")
+	if err == nil {
+		t.Fatal("Expected error looking up missing name")
+	}
+
+	// HTTP errors should be returned
+	failing := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	_, _, err = NewDoHLookup(failing.URL, failing.Client()).LookupTXT(ctx, "example.com")
+	if err == nil {
+		t.Fatal("Expected error from failing DoH server")
+	}
+}
+
+func TestDNSResolverOverHTTPS(t *testing.T) {
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	vs := tu.NewMockValueStore(context.Background(), id, dstore)
+
+	server := newDoHServer(map[string][]string{
+		"_dnslink.example.com.": []string{"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"},
+	})
+	defer server.Close()
+
+	// Only HTTPS endpoints are accepted
+	for _, endpoint := range []string{"udp://8.8.8.8", "http://dns.example.com/dns-query", "dns.example.com/dns-query"} {
+		_, err := NewResolverOpts(WithDNSOverHTTPS(endpoint, nil))
+		if err == nil {
+			t.Fatalf("Expected error for non-HTTPS endpoint %s", endpoint)
+		}
+	}
+
+	opts, err := NewResolverOpts(WithDNSOverHTTPS(server.URL, server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver(vs, dag, opts)
+	testResolution(t, r, "/ipns/example.com", DefaultDepthLimit, "QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+}
@@ -0,0 +1,229 @@
+package iprs_resolver
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ld "github.com/dirkmc/go-iprs/ipld"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+// DefaultPersistentCacheTTL is how long an entry in the persistent cache
+// is used for by default before it is looked up again
+const DefaultPersistentCacheTTL = time.Hour
+
+// The prefix for persistent cache entries in the datastore
+var persistentCachePrefix = ds.NewKey("/iprs-resolver-cache")
+
+// cachedValueVerifier is implemented by ValueGetters that can check that
+// a value loaded from the persistent cache is still valid. If offline is
+// true the value is being used because the lookup failed, so the check
+// should not depend on the network.
+type cachedValueVerifier interface {
+	verifyCachedValue(ctx context.Context, k string, val []byte, record *RecordInfo, offline bool) error
+}
+
+// DatastoreCache is a ValueGetter that persists values to a datastore,
+// so that they survive a restart. It can be layered under a
+// ResolverCache.
+//
+// A persisted value is used until its TTL has passed. After that, it is
+// only used if the lookup fails for a reason that is likely to be
+// transient (eg because we're offline) and the value hasn't reached its
+// EOL. If the name is not found or its record fails verification, the
+// persisted value is removed. If the underlying ValueGetter can verify values
+// (eg the IPRS resolver checks the record is still correctly signed and
+// valid) then persisted values are verified when they are loaded. When a
+// persisted value is used because the lookup failed, the IPRS resolver
+// doesn't check if the record's certificate has been revoked, as that
+// requires a network lookup.
+type DatastoreCache struct {
+	vg  ValueGetter
+	d   ds.Datastore
+	ttl time.Duration
+}
+
+type persistedRecordInfo struct {
+	Cid              string
+	Sequence         uint64
+	VerificationType ld.IprsVerificationType
+	ValidationType   ld.IprsValidationType
+	ValidFrom        *time.Time
+	ValidTo          *time.Time
+}
+
+type persistedEntry struct {
+	Value []byte
+	// The EOL of the value
+	Eol *time.Time
+	// The time until which the value is used without looking it up
+	CacheTill time.Time
+	Record    *persistedRecordInfo
+}
+
+// NewDatastoreCache creates a persistent cache for the ValueGetter,
+// backed by the datastore. If ttl is zero, DefaultPersistentCacheTTL
+// is used.
+func NewDatastoreCache(vg ValueGetter, d ds.Datastore, ttl time.Duration) *DatastoreCache {
+	if ttl == 0 {
+		ttl = DefaultPersistentCacheTTL
+	}
+	return &DatastoreCache{vg, d, ttl}
+}
+
+func (c *DatastoreCache) GetValue(ctx context.Context, k string) ([]byte, *time.Time, error) {
+	hop := hopFromContext(ctx)
+	if hop == nil {
+		hop = &ResolveHop{}
+		ctx = contextWithHop(ctx, hop)
+	}
+
+	// Check the datastore
+	entry, err := c.load(k)
+	if err != nil {
+		log.Warningf("Failed to load %s from persistent cache: %s", k, err)
+	}
+	if entry != nil && time.Now().Before(entry.CacheTill) {
+		val, eol, record, err := c.verify(ctx, k, entry, false)
+		if err == nil {
+			log.Debugf("Found %s in persistent cache: %s", k, val)
+			hop.CacheHit = true
+			hop.Record = record
+			return val, eol, nil
+		}
+		log.Warningf("Persisted value for %s failed verification: %s", k, err)
+		entry = nil
+	}
+
+	// Go out to the resolver
+	val, eol, err := c.vg.GetValue(ctx, k)
+	if err != nil {
+		// If the name was not found or its record failed verification
+		// (eg because its certificate was revoked) the persisted value
+		// must not be used again
+		if _, ok := err.(*negativeError); ok {
+			if ierr := c.Invalidate(k); ierr != nil {
+				log.Warningf("Failed to remove %s from persistent cache: %s", k, ierr)
+			}
+			return nil, nil, err
+		}
+
+		// If the lookup failed for some other reason (eg because we're
+		// offline) fall back to the persisted value, as long as it's
+		// still valid
+		if entry != nil && (entry.Eol == nil || time.Now().Before(*entry.Eol)) {
+			pval, peol, record, verr := c.verify(ctx, k, entry, true)
+			if verr == nil {
+				log.Warningf("Lookup of %s failed, using persisted value: %s", k, err)
+				hop.CacheHit = true
+				hop.Record = record
+				return pval, peol, nil
+			}
+		}
+		return nil, nil, err
+	}
+
+	if err := c.store(k, val, eol, hop.Record); err != nil {
+		log.Warningf("Failed to store %s in persistent cache: %s", k, err)
+	}
+	return val, eol, nil
+}
+
+// Checks that a persisted entry is still valid
+func (c *DatastoreCache) verify(ctx context.Context, k string, entry *persistedEntry, offline bool) ([]byte, *time.Time, *RecordInfo, error) {
+	record, err := entry.recordInfo()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if v, ok := c.vg.(cachedValueVerifier); ok {
+		if err = v.verifyCachedValue(ctx, k, entry.Value, record, offline); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return entry.Value, entry.Eol, record, nil
+}
+
+func (c *DatastoreCache) load(k string) (*persistedEntry, error) {
+	v, err := c.d.Get(persistentCacheKey(k))
+	if err == ds.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected type %T in persistent cache for %s", v, k)
+	}
+
+	entry := new(persistedEntry)
+	if err = json.Unmarshal(b, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (c *DatastoreCache) store(k string, val []byte, eol *time.Time, record *RecordInfo) error {
+	cacheTill := time.Now().Add(c.ttl)
+	if eol != nil && eol.Before(cacheTill) {
+		cacheTill = *eol
+	}
+	entry := &persistedEntry{
+		Value:     val,
+		Eol:       eol,
+		CacheTill: cacheTill,
+	}
+	if record != nil {
+		entry.Record = &persistedRecordInfo{
+			Cid:              record.Cid.String(),
+			Sequence:         record.Sequence,
+			VerificationType: record.VerificationType,
+			ValidationType:   record.ValidationType,
+			ValidFrom:        record.ValidFrom,
+			ValidTo:          record.ValidTo,
+		}
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.d.Put(persistentCacheKey(k), b)
+}
+
+// Invalidate removes the persisted value for the key
+func (c *DatastoreCache) Invalidate(k string) error {
+	err := c.d.Delete(persistentCacheKey(k))
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (e *persistedEntry) recordInfo() (*RecordInfo, error) {
+	if e.Record == nil {
+		return nil, nil
+	}
+	c, err := cid.Decode(e.Record.Cid)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordInfo{
+		Cid:              c,
+		Sequence:         e.Record.Sequence,
+		VerificationType: e.Record.VerificationType,
+		ValidationType:   e.Record.ValidationType,
+		ValidFrom:        e.Record.ValidFrom,
+		ValidTo:          e.Record.ValidTo,
+	}, nil
+}
+
+// Keys may contain characters that have a special meaning in datastore
+// keys (eg /iprs/<cid>/id) so they are hex encoded
+func persistentCacheKey(k string) ds.Key {
+	return persistentCachePrefix.ChildString(hex.EncodeToString([]byte(k)))
+}
@@ -0,0 +1,232 @@
+package iprs_resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	certificate "github.com/dirkmc/go-iprs/certificate"
+	psh "github.com/dirkmc/go-iprs/publisher"
+	rec "github.com/dirkmc/go-iprs/record"
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestPersistentCache(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(context.Background(), id, dstore)
+	publisher := psh.NewDHTPublisher(r, dag)
+
+	// The datastore that the cache is persisted to
+	cachestore := dssync.MutexWrap(ds.NewMapDatastore())
+	ovs := &offlineValueStore{ValueStore: r}
+	var newResolver = func(persistentTTL time.Duration) *IprsResolver {
+		// Disable the in-memory cache so that only the persistent
+		// cache is used
+		return NewIprsResolver(nil, ovs, dag, &CacheOpts{size: 0, datastore: cachestore, persistentTTL: persistentTTL})
+	}
+
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, eolRecord := getEolRecord(t, c, time.Now().Add(time.Hour), r)
+	err = publisher.Publish(ctx, iprsKey, eolRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var checkResolves = func(rs *IprsResolver) {
+		res, _, err := rs.Resolve(ctx, iprsKey.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resc, err := cid.Parse([]byte(res))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resc.Equals(c) {
+			t.Fatal("Got back incorrect value")
+		}
+	}
+
+	// Resolve the record, which persists it to the datastore
+	ttl := time.Millisecond * 50
+	checkResolves(newResolver(ttl))
+
+	// Go offline, so that lookups fail
+	ovs.offline = true
+
+	// A new resolver (eg after a restart) should get the persisted value
+	checkResolves(newResolver(ttl))
+
+	// After the TTL has passed the lookup fails, so the resolver should
+	// fall back to the persisted value
+	time.Sleep(ttl + time.Millisecond)
+	checkResolves(newResolver(ttl))
+
+	// A persisted value that doesn't match its record should fail
+	// verification
+	key := persistentCacheKey(iprsKey.BasePath())
+	v, err := cachestore.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := new(persistedEntry)
+	err = json.Unmarshal(v.([]byte), entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Value = []byte("/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy")
+	b, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cachestore.Put(key, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = newResolver(ttl).Resolve(ctx, iprsKey.String())
+	if err == nil {
+		t.Fatal("Expected tampered persisted value to fail verification")
+	}
+
+	// Go back online and remove the record from routing. The record is
+	// not found, so the persisted value should not be used and should be
+	// removed from the datastore.
+	ovs.offline = false
+	err = r.DeleteValue(iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = newResolver(ttl).Resolve(ctx, iprsKey.String())
+	if err != routing.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	_, err = cachestore.Get(key)
+	if err != ds.ErrNotFound {
+		t.Fatalf("Expected persisted value to be removed, got %v", err)
+	}
+}
+
+// A value store whose lookups fail when it is offline
+type offlineValueStore struct {
+	routing.ValueStore
+	offline bool
+}
+
+var errOffline = errors.New("offline")
+
+func (o *offlineValueStore) GetValue(ctx context.Context, k string) ([]byte, error) {
+	if o.offline {
+		return nil, errOffline
+	}
+	return o.ValueStore.GetValue(ctx, k)
+}
+
+func (o *offlineValueStore) GetValues(ctx context.Context, k string, count int) ([]routing.RecvdVal, error) {
+	if o.offline {
+		return nil, errOffline
+	}
+	return o.ValueStore.GetValues(ctx, k, count)
+}
+
+func TestPersistentCacheOfflineCertRecord(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(context.Background(), id, dstore)
+	publisher := psh.NewDHTPublisher(r, dag)
+
+	caCert, caPk, err := tu.GenerateCACertificate("ca cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	childCert, childPk, err := tu.GenerateChildCertificate("child cert", caCert, caPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner := rec.NewCertRecordSigner(caCert, caPk)
+	signer := rec.NewCertRecordSigner(childCert, childPk)
+	iprsKey, err := signer.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour))
+	record, err := rec.NewRecord(vl, signer, c.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publisher.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ovs := &offlineValueStore{ValueStore: r}
+	ttl := time.Millisecond * 50
+	cachestore := dssync.MutexWrap(ds.NewMapDatastore())
+	rs := NewIprsResolver(nil, ovs, dag, &CacheOpts{size: 0, datastore: cachestore, persistentTTL: ttl})
+
+	var checkResolves = func() {
+		res, _, err := rs.Resolve(ctx, iprsKey.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resc, err := cid.Parse([]byte(res))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resc.Equals(c) {
+			t.Fatal("Got back incorrect value")
+		}
+	}
+
+	// Resolve the record, which persists it to the datastore
+	checkResolves()
+
+	// When we're offline the revocation list can't be fetched, but the
+	// resolver should still fall back to the persisted value
+	time.Sleep(ttl + time.Millisecond)
+	ovs.offline = true
+	checkResolves()
+
+	// Once we're back online, if the child cert has been revoked the
+	// persisted value should not be used, even when we go offline again
+	ovs.offline = false
+	revocations, err := rec.NewRevocationRecord(vl, caSigner, []rec.RevokedCert{rec.NewRevokedCert(childCert, caCert)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocationsKey, err := caSigner.BasePath(rec.RevocationListId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publisher.Publish(ctx, revocationsKey, revocations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rs.Resolve(ctx, iprsKey.String())
+	if err != certificate.CertificateRevokedError {
+		t.Fatalf("Expected CertificateRevokedError, got %v", err)
+	}
+	ovs.offline = true
+	_, _, err = rs.Resolve(ctx, iprsKey.String())
+	if err != errOffline {
+		t.Fatalf("Expected errOffline, got %v", err)
+	}
+}
@@ -26,7 +26,7 @@ type IpnsResolver struct {
 func NewIpnsResolver(parent *Resolver, vs routing.ValueStore, opts *CacheOpts) *IpnsResolver {
 	if opts == nil {
 		ttl := DefaultIpnsCacheTTL
-		opts = &CacheOpts{10, &ttl}
+		opts = &CacheOpts{size: 10, ttl: &ttl}
 	}
 	rs := IpnsResolver{parent: parent, vstore: vs}
 	rs.cache = NewResolverCache(&rs, opts)
@@ -65,6 +65,14 @@ func (r *IpnsResolver) Resolve(ctx context.Context, p string) (string, []string,
 	return string(val), parts[3:], nil
 }
 
+// Invalidate removes the cached value for the path
+func (r *IpnsResolver) Invalidate(p string) error {
+	if !r.Accept(p) {
+		return fmt.Errorf("IPNS resolver cannot invalidate %s", p)
+	}
+	return r.cache.Invalidate("/ipns/" + strings.Split(p, "/")[2])
+}
+
 func (r *IpnsResolver) GetValue(ctx context.Context, k string) ([]byte, *time.Time, error) {
 	// Note that we can't get here unless k is a valid IPNS path
 	// so no need for error checking
@@ -111,6 +119,9 @@ func (r *IpnsResolver) GetValue(ctx context.Context, k string) ([]byte, *time.Ti
 	var err error
 	for i := 0; i < 2; i++ {
 		err = <-resp
+		if err == routing.ErrNotFound {
+			return nil, nil, negativeErr(err)
+		}
 		if err != nil {
 			return nil, nil, err
 		}
@@ -118,7 +129,7 @@ func (r *IpnsResolver) GetValue(ctx context.Context, k string) ([]byte, *time.Ti
 
 	// Check signature with public key
 	if ok, err := pubkey.Verify(r.entryDataForSig(entry), entry.GetSignature()); err != nil || !ok {
-		return nil, nil, fmt.Errorf("Failed to verify IPNS record at %s: invalid signature", k)
+		return nil, nil, negativeErr(fmt.Errorf("Failed to verify IPNS record at %s: invalid signature", k))
 	}
 
 	eol := r.getEol(entry)
@@ -1,8 +1,10 @@
 package iprs_resolver
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	ld "github.com/dirkmc/go-iprs/ipld"
@@ -10,30 +12,62 @@ import (
 	rec "github.com/dirkmc/go-iprs/record"
 	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
 	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
 	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
 )
 
 const DefaultIprsCacheTTL = time.Minute
 
+// DefaultRecordCount is the number of candidate records that are
+// requested from the value store when resolving an IPRS path
+const DefaultRecordCount = 16
+
+// ErrExpiredRecord is returned when resolving an IPRS record whose
+// validity has expired
+var ErrExpiredRecord = rec.ErrExpiredRecord
+
+// ErrPendingRecord is returned when resolving an IPRS record that
+// is not yet valid
+var ErrPendingRecord = rec.ErrPendingRecord
+
 type IprsResolver struct {
 	parent   *Resolver
 	vstore   routing.ValueStore
 	dag      node.NodeGetter
 	cache    *ResolverCache
 	verifier *rec.MasterRecordVerifier
+	// Verifies records without checking if their certificates have been
+	// revoked, which requires a network lookup
+	offlineVerifier *rec.MasterRecordVerifier
+	checker         rec.RecordChecker
+	// If allowStale is true, records that fail validation (eg because
+	// they have expired) will still be resolved. Useful for debugging.
+	allowStale bool
 }
 
 func NewIprsResolver(parent *Resolver, vs routing.ValueStore, dag node.NodeGetter, opts *CacheOpts) *IprsResolver {
 	if opts == nil {
 		ttl := DefaultIprsCacheTTL
-		opts = &CacheOpts{10, &ttl}
+		opts = &CacheOpts{size: 10, ttl: &ttl}
+	}
+	rs := IprsResolver{
+		parent:          parent,
+		vstore:          vs,
+		dag:             dag,
+		verifier:        rec.NewRevocationCheckingMasterRecordVerifier(vs, dag),
+		offlineVerifier: rec.NewMasterRecordVerifier(dag),
+		checker:         rec.MasterRecordChecker,
 	}
-	v := rec.NewMasterRecordVerifier(dag)
-	rs := IprsResolver{parent: parent, vstore: vs, dag: dag, verifier: v}
 	rs.cache = NewResolverCache(&rs, opts)
 	return &rs
 }
 
+// SetAllowStale indicates whether records that fail validation
+// (eg expired or not yet valid records) should still be resolved
+func (r *IprsResolver) SetAllowStale(allowStale bool) {
+	r.allowStale = allowStale
+}
+
 func (r *IprsResolver) Accept(p string) bool {
 	return rsp.IsValid(p)
 }
@@ -58,6 +92,15 @@ func (r *IprsResolver) Resolve(ctx context.Context, p string) (string, []string,
 	return string(val), iprsKey.RelativePath(), nil
 }
 
+// Invalidate removes the cached value for the path
+func (r *IprsResolver) Invalidate(p string) error {
+	iprsKey, err := rsp.FromString(p)
+	if err != nil {
+		return fmt.Errorf("IPRS resolver cannot invalidate %s", p)
+	}
+	return r.cache.Invalidate(iprsKey.BasePath())
+}
+
 func (r *IprsResolver) GetValue(ctx context.Context, k string) ([]byte, *time.Time, error) {
 	iprsKey, err := rsp.FromString(k)
 	if err != nil {
@@ -65,48 +108,207 @@ func (r *IprsResolver) GetValue(ctx context.Context, k string) ([]byte, *time.Ti
 		return nil, nil, err
 	}
 
-	// Retrieve record from the value store
-	b, err := r.vstore.GetValue(ctx, k)
+	record, negative, err := r.getBestRecord(ctx, iprsKey, r.allowStale)
 	if err != nil {
-		log.Warningf("Failed to retrieve IPRS record %s from value store", iprsKey)
+		if negative {
+			return nil, nil, negativeErr(err)
+		}
 		return nil, nil, err
 	}
 
+	if hop := hopFromContext(ctx); hop != nil {
+		hop.Record = newRecordInfo(record)
+	}
+
+	eol := r.getEol(record)
+	val := record.Value
+	if !r.parent.IsResolvable(string(val)) {
+		return nil, nil, fmt.Errorf("Failed to parse IPRS record target [%s] at %s", val, iprsKey)
+	}
+
+	return val, eol, nil
+}
+
+// Checks that a value loaded from the persistent cache came from a record
+// that is still correctly signed and valid. If offline is true, the
+// record's certificate is not checked against the revocation list, so that
+// the value can be used when the revocation list can't be fetched.
+func (r *IprsResolver) verifyCachedValue(ctx context.Context, k string, val []byte, info *RecordInfo, offline bool) error {
+	if info == nil {
+		return fmt.Errorf("No record for cached value of %s", k)
+	}
+	iprsKey, err := rsp.FromString(k)
+	if err != nil {
+		return err
+	}
+
+	verifier := r.verifier
+	if offline {
+		verifier = r.offlineVerifier
+	}
+	record, err := r.fetchRecord(ctx, iprsKey, info.Cid.Bytes())
+	if err != nil {
+		return err
+	}
+	record, err = r.checkRecord(ctx, iprsKey, record, verifier)
+	if err != nil && (record == nil || !r.allowStale) {
+		return err
+	}
+	if !bytes.Equal(record.Value, val) {
+		return fmt.Errorf("Cached value of %s does not match record %s", k, info.Cid)
+	}
+	return nil
+}
+
+// GetRecord gets the current record at the IPRS path. The record is
+// correctly signed, but may have expired or not yet be valid.
+func (r *IprsResolver) GetRecord(ctx context.Context, iprsKey rsp.IprsPath) (*rec.Record, error) {
+	bp, err := rsp.FromString(iprsKey.BasePath())
+	if err != nil {
+		return nil, err
+	}
+	record, _, err := r.getBestRecord(ctx, bp, true)
+	return record, err
+}
+
+// Gets the best of the candidate records from the value store. If
+// allowStale is true and there are no currently valid records, the
+// best of the correctly signed records that failed validation is returned.
+// If the lookup fails because there are no records, or because all of
+// them failed verification or validation, the second return value is true.
+func (r *IprsResolver) getBestRecord(ctx context.Context, iprsKey rsp.IprsPath, allowStale bool) (*rec.Record, bool, error) {
+	// Retrieve candidate records from the value store
+	vals, err := r.vstore.GetValues(ctx, iprsKey.String(), DefaultRecordCount)
+	if err != nil {
+		log.Warningf("Failed to retrieve IPRS record %s from value store", iprsKey)
+		return nil, err == routing.ErrNotFound || err == ds.ErrNotFound, err
+	}
+	if len(vals) == 0 {
+		log.Warningf("No IPRS records found for %s in value store", iprsKey)
+		return nil, true, routing.ErrNotFound
+	}
+
+	// Fetch and verify each candidate record in parallel. The results are
+	// kept in the order of the candidates, so that the error that is
+	// returned doesn't depend on which fetch finished first.
+	type recordRes struct {
+		record *rec.Record
+		err    error
+		// True if the record was fetched, ie the error (if any) is
+		// from verification or validation
+		fetched bool
+	}
+	results := make([]recordRes, len(vals))
+	var wg sync.WaitGroup
+	for i, v := range vals {
+		wg.Add(1)
+		go func(i int, b []byte) {
+			defer wg.Done()
+			record, err := r.fetchRecord(ctx, iprsKey, b)
+			if err != nil {
+				results[i] = recordRes{nil, err, false}
+				return
+			}
+			record, err = r.checkRecord(ctx, iprsKey, record, r.verifier)
+			results[i] = recordRes{record, err, true}
+		}(i, v.Val)
+	}
+	wg.Wait()
+
+	// Drop any records that could not be verified or validated
+	var valid, stale []*rec.Record
+	var staleErrs []error
+	err = nil
+	negative := true
+	for _, res := range results {
+		negative = negative && res.fetched
+		switch {
+		case res.err == nil:
+			valid = append(valid, res.record)
+		case res.record != nil:
+			stale = append(stale, res.record)
+			staleErrs = append(staleErrs, res.err)
+		case err == nil:
+			err = res.err
+		}
+	}
+
+	// If there are correctly signed records that failed validation, the
+	// validation error of the best of them (eg ErrExpiredRecord) is more
+	// useful than an error from a record that couldn't be verified
+	if len(stale) > 0 {
+		if i, serr := r.checker.SelectRecord(stale); serr == nil {
+			err = staleErrs[i]
+		} else {
+			err = staleErrs[0]
+		}
+	}
+
+	usable := valid
+	if len(usable) == 0 {
+		if !allowStale || len(stale) == 0 {
+			log.Warningf("No usable IPRS records found for %s: %s", iprsKey, err)
+			return nil, negative, err
+		}
+		log.Warningf("Using invalid IPRS record at %s (allow stale is set): %s", iprsKey, err)
+		usable = stale
+	}
+
+	// Select the best record out of the candidates
+	i, err := r.checker.SelectRecord(usable)
+	if err != nil {
+		log.Warningf("Failed to select IPRS record for %s: %s", iprsKey, err)
+		return nil, false, err
+	}
+
+	return usable[i], false, nil
+}
+
+// Fetches the IPRS record with the given CID bytes from the block store
+func (r *IprsResolver) fetchRecord(ctx context.Context, iprsKey rsp.IprsPath, b []byte) (*rec.Record, error) {
 	// Unmarshall into an IPRS record CID
 	iprsCid, err := cid.Cast(b)
 	if err != nil {
 		log.Warningf("Failed to unmarshal IPRS record at %s", iprsKey)
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Retrieve node from the block store
 	n, err := r.dag.Get(ctx, iprsCid)
 	if err != nil {
 		log.Warningf("Failed to retrieve IPRS record %s with CID %s from block store", iprsKey, iprsCid)
-		return nil, nil, err
+		return nil, err
 	}
 	iprsNode, err := ld.DecodeIprsBlock(n)
 	if err != nil {
 		log.Warningf("Failed to decode IPRS record %s with CID %s from block format", iprsKey, iprsCid)
-		return nil, nil, err
+		return nil, err
 	}
-	record := rec.NewRecordFromNode(iprsNode)
+	return rec.NewRecordFromNode(iprsNode), nil
+}
+
+// Checks with the verifier that the record is correctly signed, and that
+// it is currently valid. If the record is correctly signed but fails
+// validation, both the record and the validation error are returned.
+func (r *IprsResolver) checkRecord(ctx context.Context, iprsKey rsp.IprsPath, record *rec.Record, verifier *rec.MasterRecordVerifier) (*rec.Record, error) {
+	iprsCid := record.Cid()
 
 	// Verify record signatures etc are correct
 	log.Debugf("Verifying IPRS record %s with CID %s", iprsKey, iprsCid)
-	err = r.verifier.Verify(ctx, iprsKey, record)
+	err := verifier.Verify(ctx, iprsKey, record)
 	if err != nil {
-		log.Warningf("Failed to verify IPRS record at %s", iprsKey)
-		return nil, nil, err
+		log.Warningf("Failed to verify IPRS record %s with CID %s", iprsKey, iprsCid)
+		return nil, err
 	}
 
-	eol := r.getEol(record)
-	val := record.Value
-	if !r.parent.IsResolvable(string(val)) {
-		return nil, nil, fmt.Errorf("Failed to parse IPRS record target [%s] at %s", val, iprsKey)
+	// Validate that the record is currently valid (eg not expired)
+	err = r.checker.ValidateRecord(ctx, iprsKey, record)
+	if err != nil {
+		log.Warningf("Failed to validate IPRS record %s with CID %s: %s", iprsKey, iprsCid, err)
+		return record, err
 	}
 
-	return val, eol, nil
+	return record, nil
 }
 
 func (r *IprsResolver) getEol(record *rec.Record) *time.Time {
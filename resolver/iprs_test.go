@@ -0,0 +1,224 @@
+package iprs_resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rsp "github.com/dirkmc/go-iprs/path"
+	rec "github.com/dirkmc/go-iprs/record"
+	tu "github.com/dirkmc/go-iprs/test"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestResolveInvalidRecord(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(context.Background(), id, dstore)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rec.NewKeyRecordSigner(pk)
+
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Put an EOL record that has already expired
+	expiredKey, err := s.BasePath("expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vl := rec.NewEolRecordValidation(time.Now().Add(time.Hour * -1))
+	expired, err := rec.NewRecord(vl, s, c.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRecord(t, r, dag, expiredKey, expired)
+
+	// Put a TimeRange record that is not yet valid
+	pendingKey, err := s.BasePath("pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(time.Hour)
+	rvl, err := rec.NewRangeRecordValidation(&start, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := rec.NewRecord(rvl, s, c.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRecord(t, r, dag, pendingKey, pending)
+
+	// Resolving should fail with a typed error
+	rs := NewIprsResolver(nil, r, dag, &CacheOpts{size: 0})
+	_, _, err = rs.Resolve(ctx, expiredKey.String())
+	if err != ErrExpiredRecord {
+		t.Fatalf("Expected ErrExpiredRecord, got %v", err)
+	}
+	_, _, err = rs.Resolve(ctx, pendingKey.String())
+	if err != ErrPendingRecord {
+		t.Fatalf("Expected ErrPendingRecord, got %v", err)
+	}
+
+	// The error should be returned as is by the parent resolver
+	res := NewResolver(r, dag, NoCacheOpts)
+	_, _, err = res.Resolve(ctx, expiredKey.String(), DefaultDepthLimit)
+	if err != ErrExpiredRecord {
+		t.Fatalf("Expected ErrExpiredRecord, got %v", err)
+	}
+
+	// With allow stale set the records should resolve
+	rs.SetAllowStale(true)
+	for _, k := range []string{expiredKey.String(), pendingKey.String()} {
+		val, _, err := rs.Resolve(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resc, err := cid.Parse([]byte(val))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resc.Equals(c) {
+			t.Fatal("Got back incorrect value")
+		}
+	}
+}
+
+// Returns a fixed set of values from GetValues, to simulate receiving
+// records from several different peers
+type multiValueStore struct {
+	routing.ValueStore
+	vals [][]byte
+}
+
+func (m *multiValueStore) GetValues(ctx context.Context, k string, count int) ([]routing.RecvdVal, error) {
+	var res []routing.RecvdVal
+	for _, v := range m.vals {
+		res = append(res, routing.RecvdVal{Val: v})
+	}
+	return res, nil
+}
+
+func TestResolveSelectsBestRecord(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := tu.NewMockValueStore(context.Background(), id, dstore)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherpk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rec.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath("myrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c1, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := cid.Parse("/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The value store returns the records directly, so they only
+	// need to be added to the DAG
+	var addNewRecord = func(s rec.RecordSigner, eol time.Time, c *cid.Cid) *rec.Record {
+		vl := rec.NewEolRecordValidation(eol)
+		record, err := rec.NewRecord(vl, s, c.Bytes(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addRecordToDag(t, dag, record)
+		return record
+	}
+
+	ts := time.Now()
+	older := addNewRecord(s, ts.Add(time.Hour), c1)
+	newest := addNewRecord(s, ts.Add(time.Hour*2), c2)
+	expired := addNewRecord(s, ts.Add(time.Hour*-1), c1)
+	// Has the latest EOL but is signed with the wrong key
+	forged := addNewRecord(rec.NewKeyRecordSigner(otherpk), ts.Add(time.Hour*3), c1)
+
+	mvs := &multiValueStore{r, [][]byte{
+		older.Cid().Bytes(),
+		[]byte("not a cid"),
+		expired.Cid().Bytes(),
+		newest.Cid().Bytes(),
+		forged.Cid().Bytes(),
+	}}
+	rs := NewIprsResolver(nil, mvs, dag, &CacheOpts{size: 0})
+
+	res, _, err := rs.Resolve(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resc, err := cid.Parse([]byte(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resc.Equals(c2) {
+		t.Fatal("Got back incorrect value")
+	}
+
+	// If there are no valid records, resolution should fail with the
+	// validation error of the correctly signed record, regardless of the
+	// order in which the candidates are checked
+	mvs.vals = [][]byte{forged.Cid().Bytes(), []byte("not a cid"), expired.Cid().Bytes()}
+	for i := 0; i < 10; i++ {
+		_, _, err = rs.Resolve(ctx, iprsKey.String())
+		if err != ErrExpiredRecord {
+			t.Fatalf("Expected ErrExpiredRecord, got %v", err)
+		}
+	}
+
+	mvs.vals = [][]byte{}
+	_, _, err = rs.Resolve(ctx, iprsKey.String())
+	if err != routing.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// Adds the record and the nodes it depends on to the DAG, bypassing the
+// checks that the publisher makes
+func addRecordToDag(t *testing.T, dag mdag.DAGService, record *rec.Record) {
+	nodes := append(record.DependencyNodes(), record)
+	for _, n := range nodes {
+		_, err := dag.Add(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Adds the record to the DAG and puts a reference to it in the value
+// store, bypassing the checks that the publisher makes
+func putRecord(t *testing.T, vs routing.ValueStore, dag mdag.DAGService, iprsKey rsp.IprsPath, record *rec.Record) {
+	addRecordToDag(t, dag, record)
+	err := vs.PutValue(context.Background(), iprsKey.String(), record.Cid().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
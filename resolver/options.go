@@ -0,0 +1,278 @@
+package iprs_resolver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	dns "github.com/miekg/dns"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+)
+
+// Namespaces, ie the kinds of path that can be resolved
+const (
+	NamespaceDns  = "dns"
+	NamespaceIprs = "iprs"
+	NamespaceIpns = "ipns"
+)
+
+// DefaultNamespaces are the namespaces that are enabled by default, in the
+// order in which their resolvers are tried
+var DefaultNamespaces = []string{NamespaceDns, NamespaceIprs, NamespaceIpns}
+
+// NewCacheOpts creates cache options with the given cache size and TTL.
+// A size of zero disables caching.
+func NewCacheOpts(size int, ttl time.Duration) *CacheOpts {
+	return &CacheOpts{size: size, ttl: &ttl}
+}
+
+// ResolverOption sets an option on ResolverOpts
+type ResolverOption func(opts *ResolverOpts) error
+
+// NewResolverOpts creates resolver options from the given options, eg
+// NewResolverOpts(WithCache(NamespaceIprs, 100, time.Minute), WithDepthLimit(8))
+func NewResolverOpts(options ...ResolverOption) (*ResolverOpts, error) {
+	opts := &ResolverOpts{}
+	for _, o := range options {
+		if err := o(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// DNSSEC validation wraps whichever DNS lookup was selected
+	if opts.dnssecAnchors != nil {
+		ex, ok := opts.dnsLookup.(DNSExchanger)
+		if !ok {
+			return nil, fmt.Errorf("DNSSEC validation requires a DNS lookup that queries a DNS server, eg NewDNSClientLookup or WithDNSOverHTTPS")
+		}
+		lookup, err := NewDNSSECLookup(ex, opts.dnssecAnchors)
+		if err != nil {
+			return nil, err
+		}
+		opts.dnsLookup = lookup
+	}
+	return opts, nil
+}
+
+// WithCache sets the size and TTL of the cache for the namespace. A size
+// of zero disables caching.
+func WithCache(ns string, size int, ttl time.Duration) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		if size < 0 {
+			return fmt.Errorf("Invalid cache size %d for namespace %s", size, ns)
+		}
+		copts, err := namespaceCacheOpts(opts, ns)
+		if err != nil {
+			return err
+		}
+		copts.size = size
+		copts.ttl = &ttl
+		return nil
+	}
+}
+
+// WithNegativeCache enables negative caching for the namespace, and sets
+// how long failed lookups are cached for. Only lookups that failed because
+// the name was not found, or its record failed verification, are cached.
+// Negative caching is disabled by default, or if the TTL is zero.
+func WithNegativeCache(ns string, ttl time.Duration) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		copts, err := namespaceCacheOpts(opts, ns)
+		if err != nil {
+			return err
+		}
+		copts.negativeTTL = &ttl
+		return nil
+	}
+}
+
+// WithStaleWhileRevalidate sets whether cache entries in the namespace
+// that have passed their TTL are served until the EOL of their record,
+// while they are refreshed in the background. The EOL of a DNS entry is
+// the end of its record's TTL, so it is not served once that has passed.
+// Entries with no EOL (eg DNS entries from a lookup that doesn't report
+// TTLs) are served until they are refreshed. If the refresh fails because
+// the name was not found or its record failed verification, the entry is
+// no longer served.
+func WithStaleWhileRevalidate(ns string, enabled bool) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		copts, err := namespaceCacheOpts(opts, ns)
+		if err != nil {
+			return err
+		}
+		copts.staleWhileRevalidate = enabled
+		return nil
+	}
+}
+
+// WithPersistentCache persists the values resolved in the namespace to
+// the datastore, so that they survive a restart. A persisted value is
+// used until the TTL has passed (or DefaultPersistentCacheTTL if the TTL
+// is zero), and after that only if the lookup fails.
+func WithPersistentCache(ns string, d ds.Datastore, ttl time.Duration) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		copts, err := namespaceCacheOpts(opts, ns)
+		if err != nil {
+			return err
+		}
+		copts.datastore = d
+		copts.persistentTTL = ttl
+		return nil
+	}
+}
+
+// Gets the cache options for the namespace, creating them with the
+// namespace's default values if they haven't been set
+func namespaceCacheOpts(opts *ResolverOpts, ns string) (*CacheOpts, error) {
+	var copts **CacheOpts
+	var ttl time.Duration
+	switch ns {
+	case NamespaceDns:
+		copts, ttl = &opts.dns, DefaultDnsCacheTTL
+	case NamespaceIprs:
+		copts, ttl = &opts.iprs, DefaultIprsCacheTTL
+	case NamespaceIpns:
+		copts, ttl = &opts.ipns, DefaultIpnsCacheTTL
+	default:
+		return nil, unknownNamespaceError(ns)
+	}
+	if *copts == nil {
+		*copts = &CacheOpts{size: 10, ttl: &ttl}
+	}
+	return *copts, nil
+}
+
+// WithNoCache disables caching for all namespaces
+func WithNoCache() ResolverOption {
+	return func(opts *ResolverOpts) error {
+		opts.dns = &CacheOpts{size: 0}
+		opts.iprs = &CacheOpts{size: 0}
+		opts.ipns = &CacheOpts{size: 0}
+		return nil
+	}
+}
+
+// WithNamespaces enables only the given namespaces. The resolvers for
+// the namespaces are tried in the given order.
+func WithNamespaces(namespaces ...string) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		seen := make(map[string]bool)
+		for _, ns := range namespaces {
+			if !isNamespace(ns) {
+				return unknownNamespaceError(ns)
+			}
+			if seen[ns] {
+				return fmt.Errorf("Namespace %s specified more than once", ns)
+			}
+			seen[ns] = true
+		}
+		opts.namespaces = namespaces
+		return nil
+	}
+}
+
+// WithDepthLimit sets the default depth limit for resolution. Use
+// UnlimitedDepth to allow unlimited recursion.
+func WithDepthLimit(depth int) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		if depth < 1 && depth != UnlimitedDepth {
+			return fmt.Errorf("Invalid depth limit %d", depth)
+		}
+		opts.depthLimit = &depth
+		return nil
+	}
+}
+
+// WithDNSLookup sets how DNS TXT records are looked up. By default they
+// are looked up with the system resolver, which doesn't report TTLs, so
+// values are cached for the DNS cache TTL. Use a lookup that reports TTLs
+// (eg NewDNSClientLookup) to cache values for the TTL of their records.
+func WithDNSLookup(lookup TXTLookup) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		opts.dnsLookup = lookup
+		return nil
+	}
+}
+
+// WithDNSOverHTTPS looks up DNS TXT records with DNS-over-HTTPS, by
+// sending queries to the endpoint (which must be an https URL) with the
+// HTTP client. If client is nil, http.DefaultClient is used.
+func WithDNSOverHTTPS(endpoint string, client *http.Client) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		// Plain HTTP would let an on-path attacker forge answers
+		if u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("Invalid DoH endpoint %s. Expected an https URL", endpoint)
+		}
+		opts.dnsLookup = NewDoHLookup(endpoint, client)
+		return nil
+	}
+}
+
+// WithDNSSEC enables strict mode, in which DNS TXT records are validated
+// with DNSSEC against the trust anchors (DS or DNSKEY records). Unsigned
+// and bogus answers are rejected, and only _dnslink TXT records are used.
+// It requires a DNS lookup that queries a DNS server, set with
+// WithDNSLookup or WithDNSOverHTTPS.
+func WithDNSSEC(anchors ...dns.RR) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		if len(anchors) == 0 {
+			return fmt.Errorf("DNSSEC validation requires at least one trust anchor")
+		}
+		opts.dnssecAnchors = anchors
+		return nil
+	}
+}
+
+// WithAllowStale sets whether IPRS records that have expired or are not
+// yet valid should be resolved anyway
+func WithAllowStale(allowStale bool) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		opts.allowStale = allowStale
+		return nil
+	}
+}
+
+// WithTimeout sets the maximum time spent resolving a single hop in
+// the namespace
+func WithTimeout(ns string, timeout time.Duration) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		switch ns {
+		case NamespaceDns:
+			opts.dnsTimeout = timeout
+		case NamespaceIprs:
+			opts.iprsTimeout = timeout
+		case NamespaceIpns:
+			opts.ipnsTimeout = timeout
+		default:
+			return unknownNamespaceError(ns)
+		}
+		return nil
+	}
+}
+
+// WithTotalTimeout sets the maximum time spent resolving a path, across
+// all hops
+func WithTotalTimeout(timeout time.Duration) ResolverOption {
+	return func(opts *ResolverOpts) error {
+		opts.timeout = timeout
+		return nil
+	}
+}
+
+func isNamespace(ns string) bool {
+	for _, n := range DefaultNamespaces {
+		if ns == n {
+			return true
+		}
+	}
+	return false
+}
+
+func unknownNamespaceError(ns string) error {
+	return fmt.Errorf("Unknown namespace %s. Expected one of %s", ns, DefaultNamespaces)
+}
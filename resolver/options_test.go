@@ -0,0 +1,129 @@
+package iprs_resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestResolverOptions(t *testing.T) {
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	vs := tu.NewMockValueStore(context.Background(), id, dstore)
+
+	// Invalid options are rejected
+	invalid := []ResolverOption{
+		WithCache("foo", 10, time.Minute),
+		WithCache(NamespaceIprs, -1, time.Minute),
+		WithNamespaces(NamespaceIprs, "foo"),
+		WithNamespaces(NamespaceIprs, NamespaceIprs),
+		WithDepthLimit(0),
+		WithDepthLimit(-2),
+		WithNegativeCache("foo", time.Second),
+		WithTimeout("foo", time.Second),
+	}
+	for i, o := range invalid {
+		_, err := NewResolverOpts(o)
+		if err == nil {
+			t.Fatalf("Expected error for invalid option %d", i)
+		}
+	}
+
+	// Defaults
+	r := NewResolver(vs, dag, nil)
+	if r.DepthLimit() != DefaultDepthLimit {
+		t.Fatalf("Expected default depth limit %d, got %d", DefaultDepthLimit, r.DepthLimit())
+	}
+	if len(r.resolvers) != 3 {
+		t.Fatalf("Expected 3 resolvers, got %d", len(r.resolvers))
+	}
+
+	// Unlimited depth can be set explicitly
+	opts, err := NewResolverOpts(WithDepthLimit(UnlimitedDepth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = NewResolver(vs, dag, opts)
+	if r.DepthLimit() != UnlimitedDepth {
+		t.Fatalf("Expected unlimited depth, got %d", r.DepthLimit())
+	}
+	r.resolvers = []resolver{mockResolverDns(), mockResolverIpns(), mockResolverIprs()}
+	testResolve(t, r, "/ipns/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", r.DepthLimit(), "Qmcqtw8FfrVSBaRmbWwHxt3AuySBhJLcvmFYi3Lbc4xnwj", nil)
+
+	// Setting a cache option other than the TTL keeps the namespace's
+	// default TTL
+	opts, err = NewResolverOpts(WithNegativeCache(NamespaceDns, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = NewResolver(vs, dag, opts)
+	dnsr, ok := r.resolvers[0].(*DNSResolver)
+	if !ok {
+		t.Fatalf("Expected first resolver to be DNS resolver, got %T", r.resolvers[0])
+	}
+	if dnsr.cache.ttl != DefaultDnsCacheTTL {
+		t.Fatalf("Expected default DNS cache TTL %s, got %s", DefaultDnsCacheTTL, dnsr.cache.ttl)
+	}
+
+	opts, err = NewResolverOpts(
+		WithCache(NamespaceIprs, 0, time.Minute),
+		WithAllowStale(true),
+		WithNegativeCache(NamespaceIpns, time.Second),
+		WithCache(NamespaceIpns, 5, time.Second*30),
+		WithNamespaces(NamespaceIpns, NamespaceIprs),
+		WithDepthLimit(4),
+		WithTimeout(NamespaceIprs, time.Second),
+		WithTotalTimeout(time.Second*5),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = NewResolver(vs, dag, opts)
+
+	if r.DepthLimit() != 4 {
+		t.Fatalf("Expected depth limit 4, got %d", r.DepthLimit())
+	}
+	if r.timeouts[NamespaceIprs] != time.Second || r.timeout != time.Second*5 {
+		t.Fatal("Expected timeouts to be set")
+	}
+	if !r.iprs.allowStale {
+		t.Fatal("Expected allow stale to be set")
+	}
+
+	// Only the IPNS and IPRS resolvers are enabled, in that order
+	if len(r.resolvers) != 2 {
+		t.Fatalf("Expected 2 resolvers, got %d", len(r.resolvers))
+	}
+	ipns, ok := r.resolvers[0].(*IpnsResolver)
+	if !ok {
+		t.Fatalf("Expected first resolver to be IPNS resolver, got %T", r.resolvers[0])
+	}
+	iprs, ok := r.resolvers[1].(*IprsResolver)
+	if !ok {
+		t.Fatalf("Expected second resolver to be IPRS resolver, got %T", r.resolvers[1])
+	}
+	if r.IsResolvable("/ipns/example.com") {
+		t.Fatal("Expected DNS paths not to be resolvable")
+	}
+
+	// Cache options are applied
+	if iprs.cache.cache != nil {
+		t.Fatal("Expected IPRS cache to be disabled")
+	}
+	if ipns.cache.cache == nil || ipns.cache.ttl != time.Second*30 {
+		t.Fatal("Expected IPNS cache to be enabled with TTL of 30 seconds")
+	}
+	if ipns.cache.negativeTTL != time.Second {
+		t.Fatal("Expected IPNS negative cache TTL of 1 second")
+	}
+	if iprs.cache.negativeTTL != 0 {
+		t.Fatal("Expected IPRS negative caching to be disabled by default")
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	rsp "github.com/dirkmc/go-iprs/path"
+	rec "github.com/dirkmc/go-iprs/record"
+	dns "github.com/miekg/dns"
 	node "gx/ipfs/QmNwUEK7QbwSqyKBu3mMtToo8SUc6wQJ7gdZq4gGGJqfnf/go-ipld-format"
 	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
 	logging "gx/ipfs/QmSpJByNKFX1sCsHBEp3R73FL4NF6FnQTEGyNAXHm2GS52/go-log"
@@ -21,7 +24,8 @@ const (
 	// probably don't want to use this, but it's here if you absolutely
 	// trust resolution to eventually complete and can't put an upper
 	// limit on how many steps it will take.
-	UnlimitedDepth = 0
+	// It is negative so that it never counts down to zero.
+	UnlimitedDepth = -1
 )
 
 // ErrResolveFailed signals an error when attempting to resolve.
@@ -30,16 +34,56 @@ var ErrResolveFailed = errors.New("Could not resolve name.")
 // ErrResolveRecursion signals a recursion-depth limit.
 var ErrResolveRecursion = errors.New("Could not resolve name (recursion limit exceeded).")
 
+// ResolveTimeoutError is returned when a resolution times out. It
+// identifies the hop that was being resolved when the timeout expired.
+type ResolveTimeoutError struct {
+	// The path that was being resolved
+	Path string
+	// The name of the resolver that was resolving the path
+	Resolver string
+	// The timeout that expired
+	Timeout time.Duration
+	// True if the total resolution timeout expired, false if the
+	// timeout for the hop expired
+	Total bool
+}
+
+func (e *ResolveTimeoutError) Error() string {
+	if e.Total {
+		return fmt.Sprintf("Resolution timed out after %s while resolving %s with %s resolver", e.Timeout, e.Path, e.Resolver)
+	}
+	return fmt.Sprintf("Timed out resolving %s with %s resolver after %s", e.Path, e.Resolver, e.Timeout)
+}
+
 type ResolverOpts struct {
 	dns  *CacheOpts
 	iprs *CacheOpts
 	ipns *CacheOpts
+	// If allowStale is true, IPRS records that have expired or are not
+	// yet valid are resolved anyway. It should only be used for debugging.
+	allowStale bool
+	// The maximum time spent resolving a single hop with each kind of
+	// resolver. Zero means no timeout.
+	dnsTimeout  time.Duration
+	iprsTimeout time.Duration
+	ipnsTimeout time.Duration
+	// The maximum time spent resolving a path, across all hops. Zero
+	// means no timeout.
+	timeout time.Duration
+	// The enabled namespaces, in the order their resolvers are tried
+	namespaces []string
+	// The default depth limit. Nil means DefaultDepthLimit.
+	depthLimit *int
+	// How DNS TXT records are looked up
+	dnsLookup TXTLookup
+	// The DNSSEC trust anchors, if DNSSEC strict mode is enabled
+	dnssecAnchors []dns.RR
 }
 
 var NoCacheOpts = &ResolverOpts{
-	dns:  &CacheOpts{0, nil},
-	iprs: &CacheOpts{0, nil},
-	ipns: &CacheOpts{0, nil},
+	dns:  &CacheOpts{size: 0},
+	iprs: &CacheOpts{size: 0},
+	ipns: &CacheOpts{size: 0},
 }
 
 type resolver interface {
@@ -47,34 +91,135 @@ type resolver interface {
 	Resolve(ctx context.Context, p string) (string, []string, error)
 }
 
+// invalidator is implemented by resolvers that cache resolved values
+type invalidator interface {
+	Invalidate(p string) error
+}
+
 type Resolver struct {
 	resolvers []resolver
+	iprs      *IprsResolver
+	// Per-hop timeouts, by resolver name
+	timeouts   map[string]time.Duration
+	timeout    time.Duration
+	depthLimit int
+}
+
+// State that applies to all the hops of a resolution
+type resolution struct {
+	// The caller's context, before the total timeout is applied
+	caller context.Context
+	// If not nil, called with each hop once it has been resolved
+	trace func(*ResolveHop)
 }
 
 func NewResolver(vstore routing.ValueStore, dag node.NodeGetter, opts *ResolverOpts) *Resolver {
 	if opts == nil {
-		opts = &ResolverOpts{nil, nil, nil}
+		opts = &ResolverOpts{}
 	}
-	r := &Resolver{}
-	dns := NewDNSResolver(r, opts.dns)
+	r := &Resolver{
+		timeouts: map[string]time.Duration{
+			NamespaceDns:  opts.dnsTimeout,
+			NamespaceIprs: opts.iprsTimeout,
+			NamespaceIpns: opts.ipnsTimeout,
+		},
+		timeout:    opts.timeout,
+		depthLimit: DefaultDepthLimit,
+	}
+	if opts.depthLimit != nil {
+		r.depthLimit = *opts.depthLimit
+	}
+
 	iprs := NewIprsResolver(r, vstore, dag, opts.iprs)
-	ipns := NewIpnsResolver(r, vstore, opts.ipns)
-	r.resolvers = []resolver{dns, iprs, ipns}
+	iprs.SetAllowStale(opts.allowStale)
+	resolvers := map[string]resolver{
+		NamespaceDns:  NewDNSResolver(r, opts.dns, opts.dnsLookup),
+		NamespaceIprs: iprs,
+		NamespaceIpns: NewIpnsResolver(r, vstore, opts.ipns),
+	}
+
+	namespaces := opts.namespaces
+	if namespaces == nil {
+		namespaces = DefaultNamespaces
+	}
+	for _, ns := range namespaces {
+		r.resolvers = append(r.resolvers, resolvers[ns])
+	}
+	r.iprs = iprs
 	return r
 }
 
+// DepthLimit is the default depth limit for resolution
+func (r *Resolver) DepthLimit() int {
+	return r.depthLimit
+}
+
+// GetRecord gets the current IPRS record at the given path (bypassing
+// the cache). The record is correctly signed, but may have expired or
+// not yet be valid.
+func (r *Resolver) GetRecord(ctx context.Context, iprsKey rsp.IprsPath) (*rec.Record, error) {
+	return r.iprs.GetRecord(ctx, iprsKey)
+}
+
 // /ipfs/<cid>/some/path
 // /iprs/www.example.com/some/path
 // /iprs/<cid>/id/some/path
 // /ipns/www.example.com/some/path
 // /ipns/<cid>/some/path
 func (r *Resolver) Resolve(ctx context.Context, p string, depth int) (*node.Link, []string, error) {
-	return r.resolveWithAppendage(ctx, p, depth, []string{})
+	return r.resolve(ctx, p, depth, nil)
+}
+
+// ResolveTrace resolves the path in the same way as Resolve, and also
+// returns each of the steps taken to resolve it, in order
+func (r *Resolver) ResolveTrace(ctx context.Context, p string, depth int) (*node.Link, []string, []*ResolveHop, error) {
+	var hops []*ResolveHop
+	lnk, rest, err := r.resolve(ctx, p, depth, func(hop *ResolveHop) {
+		hops = append(hops, hop)
+	})
+	return lnk, rest, hops, err
 }
 
-func (r *Resolver) resolveWithAppendage(ctx context.Context, p string, depth int, apnd []string) (*node.Link, []string, error) {
+// ResolveTraceAsync resolves the path in the same way as Resolve,
+// streaming each step on the returned channel as it is resolved. The
+// channel is closed when resolution is complete. If resolution fails,
+// the last hop has a non-nil Err. The caller should read from the
+// channel until it is closed, or cancel the context.
+func (r *Resolver) ResolveTraceAsync(ctx context.Context, p string, depth int) <-chan *ResolveHop {
+	out := make(chan *ResolveHop)
+	go func() {
+		defer close(out)
+		r.resolve(ctx, p, depth, func(hop *ResolveHop) {
+			select {
+			case out <- hop:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return out
+}
+
+func (r *Resolver) resolve(ctx context.Context, p string, depth int, trace func(*ResolveHop)) (*node.Link, []string, error) {
+	res := &resolution{caller: ctx, trace: trace}
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	return r.resolveWithAppendage(ctx, res, p, depth, []string{})
+}
+
+func (r *Resolver) resolveWithAppendage(ctx context.Context, res *resolution, p string, depth int, apnd []string) (*node.Link, []string, error) {
 	log.Debugf("Resolve %s (%d)", p, depth)
 
+	hop := &ResolveHop{Path: p}
+	var emit = func(err error) {
+		hop.Err = err
+		if res.trace != nil {
+			res.trace(hop)
+		}
+	}
+
 	// Get the resolver for this kind of path
 	rsv := r.getResolver(p)
 	if rsv == nil {
@@ -83,26 +228,83 @@ func (r *Resolver) resolveWithAppendage(ctx context.Context, p string, depth int
 		c, rest, err := rsp.ParseTargetToCid([]byte(p))
 		if err == nil {
 			log.Debugf("Resolved %s to Node %s (%d)", p, c, depth)
+			hop.Value = c.String()
+			emit(nil)
 			return &node.Link{Cid: c}, appendParts(rest, apnd), nil
 		}
 
-		return nil, nil, fmt.Errorf("Could not resolve %s: unrecognized format", p)
+		err = fmt.Errorf("Could not resolve %s: unrecognized format", p)
+		emit(err)
+		return nil, nil, err
 	}
+	hop.Resolver = resolverName(rsv)
 
 	// If we've recursed up to the limit, bail out with an error
 	if depth == 0 {
 		log.Debugf("Could not resolve name %s (reached recursion limit)", p)
+		emit(ErrResolveRecursion)
 		return nil, nil, ErrResolveRecursion
 	}
 
 	// Resolve the path
-	res, rest, err := rsv.Resolve(ctx, p)
+	// Note: the error is returned as is so that callers can check for
+	// specific errors (eg ErrExpiredRecord)
+	val, rest, err := r.resolveHop(ctx, res, rsv, hop)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Could not resolve %s: %s", p, err)
+		log.Debugf("Could not resolve %s: %s", p, err)
+		emit(err)
+		return nil, nil, err
 	}
+	hop.Value = val
+	emit(nil)
 
 	// Recurse
-	return r.resolveWithAppendage(ctx, res, depth-1, appendParts(rest, apnd))
+	return r.resolveWithAppendage(ctx, res, val, depth-1, appendParts(rest, apnd))
+}
+
+// Resolves a single hop, applying the resolver's timeout. If a timeout
+// expires, returns a *ResolveTimeoutError that identifies the hop.
+func (r *Resolver) resolveHop(ctx context.Context, res *resolution, rsv resolver, hop *ResolveHop) (string, []string, error) {
+	hopctx := ctx
+	timeout := r.timeouts[hop.Resolver]
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		hopctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if res.trace != nil {
+		hopctx = contextWithHop(hopctx, hop)
+	}
+
+	val, rest, err := rsv.Resolve(hopctx, hop.Path)
+	if err == nil || hopctx.Err() != context.DeadlineExceeded {
+		return val, rest, err
+	}
+
+	// If the caller's own deadline expired, return the error as is
+	if res.caller.Err() != nil {
+		return "", nil, err
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Warningf("Resolution timed out after %s while resolving %s with %s resolver", r.timeout, hop.Path, hop.Resolver)
+		return "", nil, &ResolveTimeoutError{hop.Path, hop.Resolver, r.timeout, true}
+	}
+	log.Warningf("Timed out resolving %s with %s resolver after %s", hop.Path, hop.Resolver, timeout)
+	return "", nil, &ResolveTimeoutError{hop.Path, hop.Resolver, timeout, false}
+}
+
+// Invalidate removes the cached value for the path (eg because a new
+// record has been published to it), so that it is looked up again the
+// next time it is resolved
+func (r *Resolver) Invalidate(p string) error {
+	rsv := r.getResolver(p)
+	if rsv == nil {
+		return fmt.Errorf("Could not invalidate %s: unrecognized format", p)
+	}
+	if inv, ok := rsv.(invalidator); ok {
+		return inv.Invalidate(p)
+	}
+	return nil
 }
 
 func (r *Resolver) getResolver(p string) resolver {
@@ -2,7 +2,15 @@ package iprs_resolver
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
+
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
 	// gologging "gx/ipfs/QmQvJiADDe7JR4m968MwXobTCCzUqQkP87aRHe29MEBGHV/go-logging"
 	// logging "gx/ipfs/QmSpJByNKFX1sCsHBEp3R73FL4NF6FnQTEGyNAXHm2GS52/go-log"
 )
@@ -90,3 +98,78 @@ func TestRootResolution(t *testing.T) {
 	testResolve(t, r, "/ipns/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", 2, "", ErrResolveRecursion)
 	testResolve(t, r, "/ipns/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", 3, "", ErrResolveRecursion)
 }
+
+// Simulates a slow DNS server
+func slowLookupTXT(delay time.Duration, entries map[string][]string) TXTLookupFunc {
+	return func(ctx context.Context, name string) ([]string, time.Duration, error) {
+		time.Sleep(delay)
+		txt, ok := entries[name]
+		if !ok {
+			return nil, UnknownTTL, fmt.Errorf("No TXT entry for %s", name)
+		}
+		return txt, UnknownTTL, nil
+	}
+}
+
+func TestResolveTimeouts(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	vs := tu.NewMockValueStore(context.Background(), id, dstore)
+
+	entries := map[string][]string{
+		"slow1.example.com": []string{"dnslink=/ipns/slow2.example.com"},
+		"slow2.example.com": []string{"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"},
+	}
+	var newResolver = func(delay time.Duration, options ...ResolverOption) *Resolver {
+		opts, err := NewResolverOpts(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewResolver(vs, dag, opts)
+		dns := &DNSResolver{parent: r, lookup: slowLookupTXT(delay, entries)}
+		dns.cache = NewResolverCache(dns, &CacheOpts{size: 0})
+		r.resolvers[0] = dns
+		return r
+	}
+
+	// Each hop is well within the timeouts
+	r := newResolver(time.Millisecond*10, WithTimeout(NamespaceDns, time.Second), WithTotalTimeout(time.Second*2))
+	testResolve(t, r, "/ipns/slow1.example.com", DefaultDepthLimit, "QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+
+	// The first DNS hop exceeds the per-hop timeout
+	r = newResolver(time.Millisecond*200, WithTimeout(NamespaceDns, time.Millisecond*50))
+	_, _, err := r.Resolve(ctx, "/ipns/slow1.example.com", DefaultDepthLimit)
+	terr, ok := err.(*ResolveTimeoutError)
+	if !ok {
+		t.Fatalf("Expected ResolveTimeoutError, got %v", err)
+	}
+	if terr.Total || terr.Path != "/ipns/slow1.example.com" || terr.Resolver != "dns" || terr.Timeout != time.Millisecond*50 {
+		t.Fatalf("Unexpected timeout error %+v", terr)
+	}
+
+	// Each DNS hop is within the per-hop timeout, but together they
+	// exceed the total timeout
+	r = newResolver(time.Millisecond*200, WithTimeout(NamespaceDns, time.Second), WithTotalTimeout(time.Millisecond*300))
+	_, _, hops, err := r.ResolveTrace(ctx, "/ipns/slow1.example.com", DefaultDepthLimit)
+	terr, ok = err.(*ResolveTimeoutError)
+	if !ok {
+		t.Fatalf("Expected ResolveTimeoutError, got %v", err)
+	}
+	if !terr.Total || terr.Path != "/ipns/slow2.example.com" || terr.Resolver != "dns" || terr.Timeout != time.Millisecond*300 {
+		t.Fatalf("Unexpected timeout error %+v", terr)
+	}
+	if len(hops) != 2 || hops[1].Err != err {
+		t.Fatal("Expected the trace to end with the hop that timed out")
+	}
+
+	// If the caller's deadline expires, the context error is returned
+	r = newResolver(time.Millisecond*200, WithTimeout(NamespaceDns, time.Second))
+	timectx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	_, _, err = r.Resolve(timectx, "/ipns/slow1.example.com", DefaultDepthLimit)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
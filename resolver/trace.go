@@ -0,0 +1,91 @@
+package iprs_resolver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ld "github.com/dirkmc/go-iprs/ipld"
+	rec "github.com/dirkmc/go-iprs/record"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+// ResolveHop describes a single step in the resolution of a path
+type ResolveHop struct {
+	// The path that was resolved at this step
+	Path string
+	// The name of the resolver that accepted the path, eg "dns", "iprs"
+	// or "ipns". Empty if the path is a CID, ie resolution is complete.
+	Resolver string
+	// The path or CID that the path resolved to
+	Value string
+	// True if the value was found in the resolver's cache
+	CacheHit bool
+	// True if the cached value was stale, ie it was served while being
+	// refreshed in the background
+	Stale bool
+	// The IPRS record that the value came from (IPRS paths only)
+	Record *RecordInfo
+	// The error, if resolution failed at this step
+	Err error
+}
+
+// RecordInfo describes the IPRS record that a path was resolved with
+type RecordInfo struct {
+	Cid              *cid.Cid
+	Sequence         uint64
+	VerificationType ld.IprsVerificationType
+	ValidationType   ld.IprsValidationType
+	// The validity window of the record. A nil value means that the
+	// window is unbounded at that end.
+	ValidFrom *time.Time
+	ValidTo   *time.Time
+}
+
+func newRecordInfo(record *rec.Record) *RecordInfo {
+	info := &RecordInfo{
+		Cid:              record.Cid(),
+		Sequence:         record.Sequence,
+		VerificationType: record.Validity.VerificationType,
+		ValidationType:   record.Validity.ValidationType,
+	}
+	switch record.Validity.ValidationType {
+	case ld.ValidationType_EOL:
+		eol, err := rec.EolParseValidation(record)
+		if err == nil {
+			info.ValidTo = &eol
+		}
+	case ld.ValidationType_TimeRange:
+		rng, err := rec.RangeParseValidation(record)
+		if err == nil {
+			info.ValidFrom = rng[0]
+			info.ValidTo = rng[1]
+		}
+	}
+	return info
+}
+
+type hopKey struct{}
+
+// The hop being resolved is passed down to the resolver and its cache
+// through the context, so that they can fill in the details
+func contextWithHop(ctx context.Context, hop *ResolveHop) context.Context {
+	return context.WithValue(ctx, hopKey{}, hop)
+}
+
+func hopFromContext(ctx context.Context) *ResolveHop {
+	hop, _ := ctx.Value(hopKey{}).(*ResolveHop)
+	return hop
+}
+
+func resolverName(rsv resolver) string {
+	switch rsv.(type) {
+	case *DNSResolver:
+		return NamespaceDns
+	case *IprsResolver:
+		return NamespaceIprs
+	case *IpnsResolver:
+		return NamespaceIpns
+	}
+	return fmt.Sprintf("%T", rsv)
+}
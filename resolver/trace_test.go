@@ -0,0 +1,108 @@
+package iprs_resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ld "github.com/dirkmc/go-iprs/ipld"
+	psh "github.com/dirkmc/go-iprs/publisher"
+	tu "github.com/dirkmc/go-iprs/test"
+	dstest "github.com/ipfs/go-ipfs/merkledag/test"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+	cid "gx/ipfs/QmeSrf6pzut73u6zLQkRFQ3ygt3k6XFT2kjdYP8Tnkwwyg/go-cid"
+)
+
+func TestResolveTrace(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	vs := tu.NewMockValueStore(context.Background(), id, dstore)
+	publisher := psh.NewDHTPublisher(vs, dag)
+
+	c, err := cid.Parse("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eol := time.Now().Add(time.Hour).Round(time.Second)
+	iprsKey, record := getEolRecord(t, c, eol, vs)
+	err = publisher.Publish(ctx, iprsKey, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// example.com => IPRS record => IPFS CID (the record value is the
+	// CID bytes)
+	r := NewResolver(vs, dag, nil)
+	mock := &mockDNS{map[string][]string{
+		"example.com": []string{"dnslink=" + iprsKey.String()},
+	}}
+	dns := &DNSResolver{parent: r, lookup: mock}
+	dns.cache = NewResolverCache(dns, nil)
+	r.resolvers[0] = dns
+
+	var checkHops = func(hops []*ResolveHop, cacheHit bool) {
+		if len(hops) != 3 {
+			t.Fatalf("Expected 3 hops, got %d", len(hops))
+		}
+
+		h := hops[0]
+		if h.Path != "/iprs/example.com" || h.Resolver != "dns" || h.Value != iprsKey.String() {
+			t.Fatalf("Unexpected DNS hop %+v", h)
+		}
+		if h.CacheHit != cacheHit || h.Record != nil || h.Err != nil {
+			t.Fatalf("Unexpected DNS hop %+v", h)
+		}
+
+		h = hops[1]
+		if h.Path != iprsKey.String() || h.Resolver != "iprs" || h.Value != string(c.Bytes()) {
+			t.Fatalf("Unexpected IPRS hop %+v", h)
+		}
+		if h.CacheHit != cacheHit || h.Err != nil {
+			t.Fatalf("Unexpected IPRS hop %+v", h)
+		}
+		info := h.Record
+		if info == nil || !info.Cid.Equals(record.Cid()) {
+			t.Fatal("Expected IPRS hop to have the record CID")
+		}
+		if info.VerificationType != ld.VerificationType_Key || info.ValidationType != ld.ValidationType_EOL {
+			t.Fatalf("Unexpected IPRS record info %+v", info)
+		}
+		if info.ValidFrom != nil || info.ValidTo == nil || !info.ValidTo.Equal(eol) {
+			t.Fatalf("Unexpected IPRS record validity window %+v", info)
+		}
+
+		h = hops[2]
+		if h.Path != string(c.Bytes()) || h.Resolver != "" || h.Value != c.String() {
+			t.Fatalf("Unexpected final hop %+v", h)
+		}
+	}
+
+	lnk, _, hops, err := r.ResolveTrace(ctx, "/iprs/example.com", DefaultDepthLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lnk.Cid.Equals(c) {
+		t.Fatal("Got back incorrect value")
+	}
+	checkHops(hops, false)
+
+	// The second time around the values should come from the cache
+	var streamed []*ResolveHop
+	for hop := range r.ResolveTraceAsync(ctx, "/iprs/example.com", DefaultDepthLimit) {
+		streamed = append(streamed, hop)
+	}
+	checkHops(streamed, true)
+
+	// A failed hop should be the last hop, and have the error
+	_, _, hops, err = r.ResolveTrace(ctx, "/iprs/example.com", 1)
+	if err != ErrResolveRecursion {
+		t.Fatalf("Expected ErrResolveRecursion, got %v", err)
+	}
+	if len(hops) != 2 || hops[1].Resolver != "iprs" || hops[1].Err != ErrResolveRecursion {
+		t.Fatal("Expected last hop to have recursion error")
+	}
+}
@@ -1,27 +1,128 @@
 package iprs_test
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"fmt"
 	"math/big"
 	"time"
+
+	c "github.com/dirkmc/go-iprs/certificate"
 )
 
+type KeyType int
+
+const (
+	RSA KeyType = iota
+	ECDSA
+	Ed25519
+)
+
+func GenerateKey(kt KeyType) (crypto.Signer, error) {
+	switch kt {
+	case RSA:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case ECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+	return nil, fmt.Errorf("Unrecognized key type %d", kt)
+}
+
 func GenerateCACertificate(org string) (*x509.Certificate, *rsa.PrivateKey, error) {
 	return GenerateCertificate(org, nil, nil, true)
 }
 
-func GenerateChildCertificate(org string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey, error) {
+func GenerateChildCertificate(org string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, *rsa.PrivateKey, error) {
 	return GenerateCertificate(org, parent, parentKey, false)
 }
 
-func GenerateCertificate(org string, parent *x509.Certificate, parentKey *rsa.PrivateKey, isCA bool) (*x509.Certificate, *rsa.PrivateKey, error) {
+func GenerateCertificate(org string, parent *x509.Certificate, parentKey crypto.Signer, isCA bool) (*x509.Certificate, *rsa.PrivateKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := GenerateCertificateWithKey(org, priv, parent, parentKey, isCA)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, priv, nil
+}
+
+// Generates a CA certificate with a key of the given type
+func GenerateCACertificateWithKeyType(org string, kt KeyType) (*x509.Certificate, crypto.Signer, error) {
+	priv, err := GenerateKey(kt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := GenerateCertificateWithKey(org, priv, nil, nil, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, priv, nil
+}
+
+// Generates a child certificate with a key of the given type
+func GenerateChildCertificateWithKeyType(org string, kt KeyType, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer, error) {
+	priv, err := GenerateKey(kt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := GenerateCertificateWithKey(org, priv, parent, parentKey, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, priv, nil
+}
+
+// Generates a certificate that may only sign records for the given IDs
+func GenerateIdConstrainedCertificate(org string, ids []string, parent *x509.Certificate, parentKey crypto.Signer, isCA bool) (*x509.Certificate, *rsa.PrivateKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	template, err := NewCertificate(org)
 	if err != nil {
 		return nil, nil, err
 	}
+	if isCA {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	ext, err := c.NewIdConstraintsExtension(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.ExtraExtensions = append(template.ExtraExtensions, ext)
+
+	cert, err := GenerateCertificateFromTemplate(template, priv, parent, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, priv, nil
+}
+
+func GenerateCertificateWithKey(org string, priv crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer, isCA bool) (*x509.Certificate, error) {
+	template, err := NewCertificate(org)
+	if err != nil {
+		return nil, err
+	}
 
 	if isCA {
 		template.IsCA = true
@@ -30,11 +131,12 @@ func GenerateCertificate(org string, parent *x509.Certificate, parentKey *rsa.Pr
 	template.KeyUsage |= x509.KeyUsageKeyEncipherment
 	template.KeyUsage |= x509.KeyUsageKeyAgreement
 
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, nil, err
-	}
+	return GenerateCertificateFromTemplate(template, priv, parent, parentKey)
+}
 
+// Generates a certificate from the template as is. If parent is nil
+// the certificate is self-signed.
+func GenerateCertificateFromTemplate(template *x509.Certificate, priv crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, error) {
 	parentCertIprsKey := parentKey
 	if parentCertIprsKey == nil {
 		parentCertIprsKey = priv
@@ -43,17 +145,12 @@ func GenerateCertificate(org string, parent *x509.Certificate, parentKey *rsa.Pr
 	if parentCert == nil {
 		parentCert = template
 	}
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, parentCert, &priv.PublicKey, parentCertIprsKey)
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, parentCert, priv.Public(), parentCertIprsKey)
 	if err != nil {
-		return nil, nil, err
-	}
-
-	cert, err := x509.ParseCertificate(derBytes)
-	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	return cert, priv, nil
+	return x509.ParseCertificate(derBytes)
 }
 
 func NewCertificate(org string) (*x509.Certificate, error) {
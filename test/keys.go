@@ -0,0 +1,52 @@
+package iprs_test
+
+import (
+	"encoding/base64"
+
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// TestKey is a fixed private key of a particular type, so that tests that
+// check each key type are deterministic
+type TestKey struct {
+	Name string
+	// The marshalled private key, base64 encoded
+	marshalled string
+}
+
+// PrivKey unmarshals the private key
+func (k TestKey) PrivKey() (ci.PrivKey, error) {
+	b, err := base64.StdEncoding.DecodeString(k.marshalled)
+	if err != nil {
+		return nil, err
+	}
+	return ci.UnmarshalPrivateKey(b)
+}
+
+// TestKeys has a private key of each type that can sign key records
+var TestKeys = []TestKey{
+	{
+		Name: "RSA",
+		marshalled: "CAAS4QQwggJdAgEAAoGBAMhOH+oUoR8KMnB+GQnP04iztPpgeU91h9CGE8BUi1Dn" +
+			"tf2AN812J3eWYQ2QL8ghcW5pT2HE5UKDPBULv/7NruSJXbc2X22lPIe5xzXxknCY" +
+			"bCU5Rld1ohapqPG0Cq/P/ikKpmemIiHqB6Uw28/2/noYxRccxZts3Hx7yzmGaJzh" +
+			"AgMBAAECgYBM7WApZMHaa26isHMKRyRdCHN1gy6j2yYNaijUwUN+D3dgA+mqgXm3" +
+			"3muoq1sGd/2Z410G1fkcec1N4eTbRYAofD4nkXkza6ZSIMabRWoo/CkReMtz6nhM" +
+			"9QO/3GUsG7cT9JMeQwdLnhx/eUTjRr44j2QreONm8P/Z8UJmlvQ6ZwJBAODGG5zI" +
+			"MI3tqU/D+pa4EXz88KcGFZGp4HcemQFX/VhPJSZc85/950txjsYiLeH2K/su4JhT" +
+			"1ia8xY6JbEzmsA8CQQDkIc8J6CZ8yDd3NlX1ADv2hJ/NBm7RGFKKeNfn4IHpgDOF" +
+			"a78dg5S8YEbdKn7g/LzSNPQqfjYl5ihAWyTzivQPAkEAjOyIKV712jY0XfdQaCiV" +
+			"hliLhRxyb3Yjbmu3oDkLdM+CSjAi6p9k4U8cJasOO0p9PqmgVMDTVkwqTHhqdzh3" +
+			"DwJAfwt48kpvjJQvl1sSGYPM4OdQ2uvXMOi7ptPPYRl9H50+k/HCF9ycKx/utuIg" +
+			"kIkPWn0U8MkivI5lIhngBST/7wJBAILcnCcujRBDc7dz/Ij8Og0fInoV9pF4lq3S" +
+			"NCxNJ+52WC22aO3ylRmJIE5/mF4xHku8eYy4ZIUFmDOI8Nrs7Z4=",
+	},
+	{
+		Name:       "Ed25519",
+		marshalled: "CAESYApYYq7ITIiyf9bLabQxZmre9L1NkEGzuvf32sYvxoIsLmH4N8Lhpx+mmlb6USVQxb+eavppIMCfY8tDqNhjnQIuYfg3wuGnH6aaVvpRJVDFv55q+mkgwJ9jy0Oo2GOdAg==",
+	},
+	{
+		Name:       "Secp256k1",
+		marshalled: "CAISIHy1fxvTooUAILzoFCYrK2hpAHvSUJoujKS+lx20+K1C",
+	},
+}